@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Audited actions
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRefresh = "refresh" // data refreshed by a background job
+)
+
+// Audited entities
+const (
+	auditDomain   = "domain"
+	auditCert     = "cert"
+	auditClient   = "client"
+	auditUser     = "user"
+	auditAPIToken = "api_token"
+	auditSession  = "session"
+	auditNSChange = "ns_change"
+	auditCABundle = "ca_bundle"
+)
+
+// auditActor is who made a change: a user, or a background job
+type auditActor struct {
+	Name   string
+	UserID *int
+}
+
+func userActor(r *http.Request) auditActor {
+	user := currentUser(r)
+	return auditActor{Name: user.Username, UserID: &user.ID}
+}
+
+func systemActor(job string) auditActor {
+	return auditActor{Name: "system:" + job}
+}
+
+// recordAudit appends an entry to the audit log, before/after are marshaled to JSON (nil for creations/deletions)
+// Failing to record is logged but doesn't fail the change
+func recordAudit(actor auditActor, action, entity string, entityID int, before, after any) {
+	_, err := db.Exec(context.TODO(), "INSERT INTO audit_log (actor, userId, action, entity, entityId, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		actor.Name, actor.UserID, action, entity, entityID, auditJSON(before), auditJSON(after))
+	if err != nil {
+		log.Printf("Failed to record audit entry (%s %s %d by %s): %v\n", action, entity, entityID, actor.Name, err)
+	}
+}
+
+// Timestamps every check moves forward, a refresh that only changed them changed nothing
+var auditCheckTimestamps = []string{"checkedAt", "lastCheckedAt", "nextCheckAt"}
+
+// recordRefresh audits a background job's refresh, only when it changed more than the check timestamps
+// Jobs refresh every domain and certificate on each run, an entry for each would bury the real changes
+func recordRefresh(job, entity string, entityID int, before, after any) {
+	if !auditChanged(before, after) {
+		return
+	}
+	recordAudit(systemActor(job), auditRefresh, entity, entityID, before, after)
+}
+
+// auditChanged compares two values as the audit log stores them, ignoring the check timestamps
+func auditChanged(before, after any) bool {
+	return !reflect.DeepEqual(withoutCheckTimestamps(before), withoutCheckTimestamps(after))
+}
+
+func withoutCheckTimestamps(v any) any {
+	var decoded any
+	if err := json.Unmarshal(auditJSON(v), &decoded); err != nil {
+		return nil
+	}
+	var strip func(v any)
+	strip = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for _, k := range auditCheckTimestamps {
+				delete(v, k)
+			}
+			for _, e := range v {
+				strip(e)
+			}
+		case []any:
+			for _, e := range v {
+				strip(e)
+			}
+		}
+	}
+	strip(decoded)
+	return decoded
+}
+
+// auditJSON marshals a value for the audit log (nil stays SQL NULL)
+func auditJSON(v any) []byte {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to marshal audit value: %v\n", err)
+		return nil
+	}
+	return b
+}
+
+// auditDomainValue strips the raw RDAP/whois data, it is large and already kept in the domain row
+func auditDomainValue(d Domain) Domain {
+	d.RawWhoisData = ""
+	return d
+}
+
+// auditCertValue strips the raw certificate data
+func auditCertValue(c TLSDomain) TLSDomain {
+	c.RawData = ""
+	return c
+}
+
+// getDomain returns a domain by ID
+func getDomain(id any) (Domain, error) {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains WHERE id = $1", id)
+	if err != nil {
+		return Domain{}, err
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[Domain])
+}
+
+// Handle the /api/audit route, lists audit log entries (newest first)
+// Filters: ?actor=, ?action=, ?entity=, ?entityID=, ?since=, ?until= (RFC 3339), ?limit= (default 100)
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	optional := func(name string) *string {
+		if v := q.Get(name); v != "" {
+			return &v
+		}
+		return nil
+	}
+	var entityID *int
+	if v := q.Get("entityID"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid entity ID", http.StatusBadRequest)
+			return
+		}
+		entityID = &id
+	}
+	var since, until *time.Time
+	for name, dst := range map[string]**time.Time{"since": &since, "until": &until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" (use RFC 3339)", http.StatusBadRequest)
+				return
+			}
+			*dst = &t
+		}
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(context.TODO(), `
+		SELECT id, at, actor, userId, action, entity, entityId, before, after FROM audit_log
+		WHERE ($1::text IS NULL OR actor = $1)
+			AND ($2::text IS NULL OR action = $2)
+			AND ($3::text IS NULL OR entity = $3)
+			AND ($4::int IS NULL OR entityId = $4)
+			AND ($5::timestamptz IS NULL OR at >= $5)
+			AND ($6::timestamptz IS NULL OR at < $6)
+		ORDER BY id DESC LIMIT $7`,
+		optional("actor"), optional("action"), optional("entity"), entityID, since, until, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	entries, err := pgx.CollectRows(rows, pgx.RowToStructByName[AuditEntry])
+	if err != nil {
+		http.Error(w, "Error reading audit log", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditChanged(t *testing.T) {
+	checked := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	later := checked.Add(24 * time.Hour)
+	domain := func(checkedAt time.Time, records ...string) Domain {
+		next := checkedAt.Add(30 * 24 * time.Hour)
+		return Domain{
+			ID: 1, Domain: "example.com", Registrar: "Registrar",
+			CAA:           &CAAPolicy{CheckedAt: checkedAt, Records: records, Problems: []string{}},
+			LastCheckedAt: &checkedAt, NextCheckAt: &next,
+		}
+	}
+	cert := func(checkedAt time.Time, status string) TLSDomain {
+		return TLSDomain{ID: 1, Domain: "example.com", Validation: &TLSValidation{CheckedAt: checkedAt, Status: status, Problems: []string{}}}
+	}
+
+	tests := []struct {
+		name          string
+		before, after any
+		want          bool
+	}{
+		{"domain checked again", domain(checked, `0 issue "letsencrypt.org"`), domain(later, `0 issue "letsencrypt.org"`), false},
+		{"domain CAA changed", domain(checked, `0 issue "letsencrypt.org"`), domain(later, `0 issue "digicert.com"`), true},
+		{"cert validated again", cert(checked, certValid), cert(later, certValid), false},
+		{"cert validation changed", cert(checked, certValid), cert(later, certExpired), true},
+	}
+	for _, tt := range tests {
+		if got := auditChanged(tt.before, tt.after); got != tt.want {
+			t.Errorf("%s: auditChanged = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
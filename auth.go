@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Roles a user can hold, from least to most privileged.
+// Client users are read-only and restricted to the clients they are assigned to.
+const (
+	RoleClient   Role = "client"
+	RoleReadOnly Role = "readonly"
+	RoleEditor   Role = "editor"
+	RoleAdmin    Role = "admin"
+)
+
+type Role string
+
+// rank orders the roles so they can be compared
+func (r Role) rank() int {
+	switch r {
+	case RoleClient:
+		return 1
+	case RoleReadOnly:
+		return 2
+	case RoleEditor:
+		return 3
+	case RoleAdmin:
+		return 4
+	}
+	return 0
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// Allows reports whether a user with role r may access something that requires min
+func (r Role) Allows(min Role) bool {
+	return r.Valid() && r.rank() >= min.rank()
+}
+
+// Scopes an API token can be limited to
+const (
+	ScopeFull     TokenScope = "full"
+	ScopeReadOnly TokenScope = "readonly"
+	ScopeTLS      TokenScope = "tls"
+)
+
+type TokenScope string
+
+// Valid reports whether s is a known scope
+func (s TokenScope) Valid() bool {
+	return s == ScopeFull || s == ScopeReadOnly || s == ScopeTLS
+}
+
+// limitRole caps a user's role to what the scope permits
+func (s TokenScope) limitRole(role Role) Role {
+	if s == ScopeReadOnly && role.Allows(RoleEditor) {
+		return RoleReadOnly
+	}
+	return role
+}
+
+// allowsPath reports whether the scope gives access to an API path.
+// TLS-only tokens may use the TLS tracker (and list clients to pick from).
+func (s TokenScope) allowsPath(path string) bool {
+	if s == ScopeTLS {
+		return strings.HasPrefix(path, "/api/tls") || path == "/api/clientList"
+	}
+	return true
+}
+
+// allowsMethod reports whether the scope permits a request method.
+// Read-only tokens can't change anything, not even the routes every role may use (revoking sessions and tokens, logout, TOTP).
+func (s TokenScope) allowsMethod(method string) bool {
+	if s == ScopeReadOnly {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return true
+}
+
+type userCtxKey struct{}
+
+// Sessions expire after this long without activity
+const sessionLifetime = 48 * time.Hour
+
+// Activity only extends a session (a database write) once this much time has passed since it was last extended
+const sessionTouchInterval = 5 * time.Minute
+
+// setSessionCookie sets (or with an empty token, clears) the session cookie
+// The cookie lives exactly as long as the session in the database
+func setSessionCookie(w http.ResponseWriter, token string) {
+	maxAge := int(sessionLifetime.Seconds())
+	if token == "" {
+		maxAge = -1
+	}
+	cookie := &http.Cookie{
+		Name:     "session",
+		Value:    token,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	// Replaces a cookie set earlier in the same response (e.g. the sliding expiry before logging out)
+	w.Header().Set("Set-Cookie", cookie.String())
+}
+
+// createSession starts a new session for the user and sets the session cookie
+func createSession(w http.ResponseWriter, r *http.Request, userID int) error {
+	// generate a new session token
+	token := generateSessionToken()
+	// store token in the database
+	_, err := db.Exec(context.TODO(), "INSERT INTO sessions (token, userId, expires, ip, userAgent) VALUES ($1, $2, $3, $4, $5)",
+		token, userID, time.Now().Add(sessionLifetime), clientIP(r), r.UserAgent())
+	if err != nil {
+		return err
+	}
+
+	setSessionCookie(w, token)
+	return nil
+}
+
+// touchSession slides the expiry of an active session (in the database and the cookie)
+func touchSession(w http.ResponseWriter, r *http.Request, user AuthUser) {
+	if time.Since(user.sessionLastSeen) < sessionTouchInterval {
+		return
+	}
+	cookie, err := r.Cookie("session")
+	if err != nil {
+		return
+	}
+
+	_, err = db.Exec(context.TODO(), "UPDATE sessions SET expires = $1, lastSeen = $2, ip = $3, userAgent = $4 WHERE id = $5",
+		time.Now().Add(sessionLifetime), time.Now(), clientIP(r), r.UserAgent(), user.SessionID)
+	if err != nil {
+		log.Printf("Failed to extend session: %v\n", err)
+		return
+	}
+	setSessionCookie(w, cookie.Value)
+}
+
+// authenticate identifies the user behind a request,
+// either from an API token (Authorization: Bearer) or the session cookie
+func authenticate(r *http.Request) (AuthUser, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return AuthUser{}, errors.New("unsupported authorization scheme")
+		}
+		return checkAPIToken(token)
+	}
+	return checkSessionToken(r)
+}
+
+// hashAPIToken returns the hash an API token is stored under
+func hashAPIToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func checkAPIToken(token string) (AuthUser, error) {
+	var user AuthUser
+	var tokenID int
+	var disabled bool
+	var expires *time.Time
+	err := db.QueryRow(context.TODO(), "SELECT t.id, t.scope, t.expires, u.id, u.username, u.role, u.disabled FROM api_tokens t JOIN users u ON u.id = t.userId WHERE t.tokenHash = $1", hashAPIToken(token)).
+		Scan(&tokenID, &user.Scope, &expires, &user.ID, &user.Username, &user.Role, &disabled)
+	if err != nil {
+		return AuthUser{}, err
+	}
+
+	if expires != nil && time.Now().After(*expires) {
+		return AuthUser{}, errors.New("token expired")
+	}
+	if disabled {
+		return AuthUser{}, errors.New("user disabled")
+	}
+
+	user.Role = user.Scope.limitRole(user.Role)
+	if user.Scoped() {
+		user.ClientIDs, err = getUserClients(user.ID)
+		if err != nil {
+			return AuthUser{}, err
+		}
+	}
+
+	if _, err := db.Exec(context.TODO(), "UPDATE api_tokens SET lastUsed = $1 WHERE id = $2", time.Now(), tokenID); err != nil {
+		return AuthUser{}, err
+	}
+	return user, nil
+}
+
+// requireRole wraps a handler so it is only reached by an authenticated user holding at least the min role
+// (and, for API tokens, a scope covering the route).
+// The authenticated user is stored in the request context (see currentUser).
+func requireRole(min Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticate(r)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !user.Role.Allows(min) || !user.Scope.allowsPath(r.URL.Path) || !user.Scope.allowsMethod(r.Method) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if user.SessionID != 0 {
+			touchSession(w, r, user)
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userCtxKey{}, user)))
+	}
+}
+
+// currentUser returns the user authenticated by requireRole
+func currentUser(r *http.Request) AuthUser {
+	user, _ := r.Context().Value(userCtxKey{}).(AuthUser)
+	return user
+}
+
+// Scoped reports whether the user may only see some clients (see ClientIDs)
+func (u AuthUser) Scoped() bool {
+	return u.Role == RoleClient
+}
+
+// clientScope returns the query argument used to filter rows by client:
+// nil (SQL NULL) for unrestricted users, otherwise the user's client IDs.
+// Use with "WHERE $n::int[] IS NULL OR clientId = ANY($n)".
+func (u AuthUser) clientScope() []int {
+	if !u.Scoped() {
+		return nil
+	}
+	if u.ClientIDs == nil {
+		return []int{}
+	}
+	return u.ClientIDs
+}
@@ -19,8 +19,44 @@ func dbCleanup() {
 		log.Printf("Failed to delete expired sessions: %v\n", err)
 	}
 	log.Printf("Deleted %d expired session(s)\n", delSess.RowsAffected())
+
+	// Delete abandoned two-factor login challenges
+	if _, err := db.Exec(context.TODO(), "DELETE FROM login_challenges WHERE expires < $1", time.Now()); err != nil {
+		log.Printf("Failed to delete expired login challenges: %v\n", err)
+	}
+	if _, err := db.Exec(context.TODO(), "DELETE FROM oidc_states WHERE expires < $1", time.Now()); err != nil {
+		log.Printf("Failed to delete expired SSO states: %v\n", err)
+	}
+
+	// Keep unchanged DNS snapshots for 90 days, the ones with changes forever
+	if _, err := db.Exec(context.TODO(), "DELETE FROM dns_snapshots WHERE NOT changed AND takenAt < $1", time.Now().AddDate(0, 0, -90)); err != nil {
+		log.Printf("Failed to delete old DNS snapshots: %v\n", err)
+	}
+
+	// Keep 90 days of login attempts
+	if _, err := db.Exec(context.TODO(), "DELETE FROM login_attempts WHERE at < $1", time.Now().AddDate(0, 0, -90)); err != nil {
+		log.Printf("Failed to delete old login attempts: %v\n", err)
+	}
+	pruneLoginLimiter()
 }
 
+// nextDomainCheck returns when a domain is refreshed next: daily (reminderRefreshDays) once it's in the reminder window, monthly (refreshDays) before
+func nextDomainCheck(expiration time.Time) time.Time {
+	conf := getConfig()
+	days := conf.RefreshDays
+	if days <= 0 {
+		days = 30
+	}
+	if time.Now().AddDate(0, 0, conf.DaysDomainExp).After(expiration) {
+		days = conf.ReminderRefreshDays
+		if days <= 0 {
+			days = 1
+		}
+	}
+	return time.Now().AddDate(0, 0, days)
+}
+
+// updateDomains refreshes the RDAP/whois data and DNS records of the domains whose next check is due
 func updateDomains(progress chan<- string) {
 	send := func(msg string) {
 		log.Println(msg)
@@ -32,8 +68,9 @@ func updateDomains(progress chan<- string) {
 		}
 	}
 
-	// Get all domains
-	rows, err := db.Query(context.TODO(), "SELECT * FROM domains")
+	// Get the domains due for a refresh, the hour of slack keeps a domain due every day from slipping to the next run
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains WHERE nextCheckAt IS NULL OR nextCheckAt <= $1 ORDER BY nextCheckAt NULLS FIRST",
+		time.Now().Add(time.Hour))
 	if err != nil {
 		log.Printf("Failed to get domains: %v\n", err)
 		return
@@ -47,33 +84,78 @@ func updateDomains(progress chan<- string) {
 		return
 	}
 
-	send(fmt.Sprintf("Checking %d domain(s) for updates...", len(domains)))
+	send(fmt.Sprintf("%d domain(s) due for a refresh...", len(domains)))
 	refreshed := 0
+	var dnsAlerts []dnsChangeAlert
+	var mailAlerts []mailSecurityAlert
+	var statusAlerts []statusAlert
+	var contactAlerts []contactAlert
 
-	// Iterate over each domain and check expiration
+	// Refresh each due domain, one that fails stays due and is retried on the next run
 	for _, d := range domains {
-		currTime := time.Now().AddDate(0, 0, getConfig().DaysDomainExp)
+		send(fmt.Sprintf("Updating %s...", d.Domain))
+		data, err := fetchDomainData(d.Domain)
+		if err != nil {
+			send(fmt.Sprintf("Failed to fetch data for %s: %v", d.Domain, err))
+			continue
+		}
 
-		// Check if the domain expires within the configured reminder period
-		// If it does, refresh its data
-		if currTime.After(d.Expiration) {
-			send(fmt.Sprintf("Updating %s...", d.Domain))
-			exp, ns, reg, rawData, dns, err := fetchDomainData(d.Domain)
-			if err != nil {
-				send(fmt.Sprintf("Failed to fetch data for %s: %v", d.Domain, err))
-				continue
-			}
+		// The DNS records are saved by recordDNS below, with a snapshot and diff
+		rows, err := db.Query(context.TODO(), `UPDATE domains SET expiration = $1, nameservers = $2, registrar = $3, rawWhoisData = $4, ds = $5, status = $6,
+			registrantOrg = $7, registrantCountry = $8, registrantPrivacy = $9, techContact = $10, abuseContact = $11,
+			dataSource = $12, lastCheckedAt = now(), nextCheckAt = $13 WHERE id = $14 RETURNING *`,
+			data.Expiration, data.Nameservers, data.Registrar, data.RawData, data.DS, data.Status,
+			nilIfEmpty(data.Contacts.Registrant.Organization), nilIfEmpty(data.Contacts.Registrant.Country), data.Contacts.Registrant.Privacy,
+			data.Contacts.Tech, data.Contacts.Abuse, data.Source, nextDomainCheck(data.Expiration), d.ID)
+		if err != nil {
+			send(fmt.Sprintf("Failed to save %s: %v", d.Domain, err))
+			continue
+		}
+		updated, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Domain])
+		if err != nil {
+			send(fmt.Sprintf("Failed to save %s: %v", d.Domain, err))
+			continue
+		}
+		recordRefresh("updateDomains", auditDomain, d.ID, auditDomainValue(d), auditDomainValue(updated))
+		recordDomainHistory(&d, updated)
+		dnsAlert, mailAlert, err := recordDNS(updated, data.DNS, "updateDomains")
+		if err != nil {
+			send(fmt.Sprintf("Failed to save DNS records of %s: %v", d.Domain, err))
+		}
+		if dnsAlert != nil {
+			dnsAlerts = append(dnsAlerts, *dnsAlert)
+		}
+		if mailAlert != nil {
+			mailAlerts = append(mailAlerts, *mailAlert)
+		}
+		if changes := statusChanges(d.Status, data.Status); len(changes) > 0 && sameSource(d, data) {
+			statusAlerts = append(statusAlerts, statusAlert{Domain: updated, Statuses: data.Status, Changes: changes})
+		}
+		if changes := contactChanges(d, data.Contacts); len(changes) > 0 && sameSource(d, data) {
+			contactAlerts = append(contactAlerts, contactAlert{Domain: updated, Changes: changes})
+		}
 
-			_, err = db.Exec(context.TODO(), "UPDATE domains SET expiration = $1, nameservers = $2, registrar = $3, rawWhoisData = $4, dns = $5 WHERE id = $6",
-				exp, ns, reg, rawData, dns, d.ID)
-			if err != nil {
-				send(fmt.Sprintf("Failed to save %s: %v", d.Domain, err))
-				continue
-			}
+		send(fmt.Sprintf("Updated %s (expires %s)", d.Domain, data.Expiration.Format("01/02/2006")))
+		refreshed++
+		time.Sleep(15 * time.Second) // to avoid rate limiting
+	}
 
-			send(fmt.Sprintf("Updated %s (expires %s)", d.Domain, exp.Format("01/02/2006")))
-			refreshed++
-			time.Sleep(15 * time.Second) // to avoid rate limiting
+	if len(dnsAlerts) > 0 {
+		sendDNSChangeAlerts(dnsAlerts)
+	}
+	if len(mailAlerts) > 0 {
+		if err := sendMailSecurityAlerts(mailAlerts); err != nil {
+			send(fmt.Sprintf("Failed to send email security alert email: %v", err))
+		}
+	}
+	if len(statusAlerts) > 0 {
+		if err := sendStatusAlerts(statusAlerts); err != nil {
+			send(fmt.Sprintf("Failed to send domain status alert email: %v", err))
+		}
+	}
+	if len(contactAlerts) > 0 {
+		if err := sendContactAlerts(contactAlerts); err != nil {
+			send(fmt.Sprintf("Failed to send domain contact alert email: %v", err))
 		}
 	}
 
@@ -110,6 +192,10 @@ func sendExpDomReminders(progress chan<- string) {
 	}
 	// Array to hold domains needing reminders
 	var needReminder []Domain
+	// Domains whose authoritative nameservers disagree or are lame, whether or not they expire soon
+	var nsProblems []Domain
+	// Domains with a broken DNSSEC chain or signatures about to expire
+	var dnssecProblems []Domain
 
 	// Populate the array
 	for _, d := range domains {
@@ -119,15 +205,23 @@ func sendExpDomReminders(progress chan<- string) {
 		if currTime.After(d.Expiration) {
 			needReminder = append(needReminder, d)
 		}
+		if d.NSCheck != nil && len(d.NSCheck.Problems) > 0 {
+			nsProblems = append(nsProblems, d)
+		}
+		if d.DNSSEC != nil && len(d.DNSSEC.Problems) > 0 {
+			dnssecProblems = append(dnssecProblems, d)
+		}
 	}
 
 	send("Checking for expiring domains...")
 
 	var domainList string
 
-	if len(needReminder) == 0 {
+	if len(needReminder) == 0 && len(nsProblems) == 0 && len(dnssecProblems) == 0 {
 		send("No domains expiring soon, skipping email")
 		return
+	} else if len(needReminder) == 0 {
+		send("No domains expiring soon")
 	} else {
 		send(fmt.Sprintf("%d domain(s) expiring soon:", len(needReminder)))
 		warningThreshold := (time.Duration(getConfig().DaysDomainExp) * 24 * time.Hour) / 2
@@ -158,13 +252,46 @@ func sendExpDomReminders(progress chan<- string) {
 
 			subtitle := fmt.Sprintf("Expires %s &middot; Client: %s &middot; Registrar: %s",
 				d.Expiration.Format("01/02/2006"), client, d.Registrar)
+			if len(d.Status) > 0 {
+				subtitle += "<br>" + statusBadges(d.Status)
+			}
 			domainList += domainCard(borderColor, getConfig().BaseURL+"/dash/?q="+d.Domain, d.Domain, subtitle, badge)
 		}
 	}
 
+	title := "Domains expiring soon"
+	var intro string
+	if len(needReminder) > 0 {
+		intro = fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The following %d domain(s) are expiring within the next <strong>%d days</strong>. Click a domain to view it in Domain Tracker.</p>`, len(needReminder), getConfig().DaysDomainExp)
+	} else if len(nsProblems) > 0 {
+		title = "Nameserver problems detected"
+	} else {
+		title = "DNSSEC problems detected"
+	}
+
+	if len(nsProblems) > 0 {
+		send(fmt.Sprintf("%d domain(s) with nameserver problems", len(nsProblems)))
+		domainList += fmt.Sprintf(`<p style="margin:24px 0 20px;font-size:14px;color:#57606a;">The authoritative nameservers of the following %d domain(s) are lame or out of sync.</p>`, len(nsProblems))
+		for _, d := range nsProblems {
+			subtitle := fmt.Sprintf("Checked %s", d.NSCheck.CheckedAt.Format("01/02/2006"))
+			domainList += domainCard("#f85149", getConfig().BaseURL+"/dash/?q="+d.Domain, d.Domain, subtitle, strings.Join(d.NSCheck.Problems, "<br>"))
+		}
+	}
+
+	if len(dnssecProblems) > 0 {
+		send(fmt.Sprintf("%d domain(s) with DNSSEC problems", len(dnssecProblems)))
+		domainList += fmt.Sprintf(`<p style="margin:24px 0 20px;font-size:14px;color:#57606a;">The DNSSEC chain of the following %d domain(s) is broken or its signatures are about to expire.</p>`, len(dnssecProblems))
+		for _, d := range dnssecProblems {
+			subtitle := fmt.Sprintf("State: %s &middot; Checked %s", d.DNSSEC.State, d.DNSSEC.CheckedAt.Format("01/02/2006"))
+			if d.DNSSEC.SignatureExpiration != nil {
+				subtitle += " &middot; Signatures expire " + d.DNSSEC.SignatureExpiration.Format("01/02/2006 15:04 MST")
+			}
+			domainList += domainCard("#f85149", getConfig().BaseURL+"/dash/?q="+d.Domain, d.Domain, subtitle, strings.Join(d.DNSSEC.Problems, "<br>"))
+		}
+	}
+
 	send("Sending expiration reminder email...")
-	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The following %d domain(s) are expiring within the next <strong>%d days</strong>. Click a domain to view it in Domain Tracker.</p>`, len(needReminder), getConfig().DaysDomainExp)
-	err = sendEmail("Domains expiring soon", emailHTML("Domains expiring soon", intro+domainList))
+	err = sendEmail(title, emailHTML(title, intro+domainList))
 	if err != nil {
 		send(fmt.Sprintf("Failed to send email: %v", err))
 	} else {
@@ -195,9 +322,14 @@ func detectNameserverChanges() {
 			continue
 		}
 		// Fetch new nameserver
-		newNs := ResolveDNS(d.Domain, "NS")
+		newNs, err := ResolveDNS(d.Domain, "NS")
+		if err != nil {
+			log.Printf("Failed to resolve NS for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		// The domain may have lapsed or lost its delegation, leave that to the expiry checks rather than recording an empty set
 		if len(newNs) == 0 {
-			log.Printf("Failed to resolve NS for domain %s\n", d.Domain)
+			log.Printf("No NS records found for domain %s\n", d.Domain)
 			continue
 		}
 
@@ -212,12 +344,18 @@ func detectNameserverChanges() {
 		// Check if there is a change
 		if !slices.Equal(ns, newNs) {
 			// Store the change
-			NSChanges = append(NSChanges, NSChange{
+			change := NSChange{
+				DomainID:  d.ID,
 				Domain:    d.Domain,
+				ClientID:  d.ClientID,
 				OldNS:     ns,
 				NewNS:     newNs,
 				CheckedAt: time.Now(),
-			})
+			}
+			if err := saveNSChange(&change); err != nil {
+				log.Printf("Failed to save nameserver change for domain %s: %v\n", d.Domain, err)
+			}
+			NSChanges = append(NSChanges, change)
 
 			// Update the database with the new nameservers
 			_, err = db.Exec(context.TODO(), "UPDATE domains SET nameservers = $1 WHERE id = $2", newNs, d.ID)
@@ -225,6 +363,9 @@ func detectNameserverChanges() {
 				log.Printf("Failed to update nameservers for domain %s: %v\n", d.Domain, err)
 				continue
 			}
+			updated := d
+			updated.Nameservers = newNs
+			recordAudit(systemActor("detectNameserverChanges"), auditUpdate, auditDomain, d.ID, auditDomainValue(d), auditDomainValue(updated))
 		}
 
 		time.Sleep(1 * time.Second) // avoid rate limiting Google DNS
@@ -247,10 +388,10 @@ func detectNameserverChanges() {
 			strings.Join(change.OldNS, ", "),
 			strings.Join(change.NewNS, ", "),
 		)
-		listChanges += domainCard("#e3b341", getConfig().BaseURL+"/dash/?q="+change.Domain, change.Domain, subtitle, "") + nsDetails
+		listChanges += domainCard("#e3b341", fmt.Sprintf("%s/dash/nschanges/?domainID=%d", getConfig().BaseURL, change.DomainID), change.Domain, subtitle, "") + nsDetails
 	}
 
-	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">Nameserver changes were detected for <strong>%d domain(s)</strong>. The database has been updated automatically.</p>`, len(NSChanges))
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">Nameserver changes were detected for <strong>%d domain(s)</strong>. The database has been updated automatically. Click a domain to review the change in Domain Tracker.</p>`, len(NSChanges))
 	err = sendEmail("Nameserver changes detected", emailHTML("Nameserver changes detected", intro+listChanges))
 	if err != nil {
 		log.Printf("Failed to send nameserver change alert email: %v\n", err)
@@ -273,26 +414,66 @@ func updateTLSCerts() {
 		return
 	}
 
+	var chainAlerts []chainAlert
+	var ipAlerts []ipCertAlert
+	var validationAlerts []validationAlert
+
 	// Iterate over each certificate and check expiration
 	for _, d := range domains {
 		log.Printf("Refreshing certificate: %s\n", d.Domain)
-		commonName, validTo, issuer, rawData, err := getTLSCert(d.Domain)
+		endpoint, err := trustedEndpoint(d)
+		if err != nil {
+			log.Printf("Failed to load CA bundles of %s: %v\n", d.Domain, err)
+			continue
+		}
+		cert, err := getTLSCert(endpoint)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
 
-		_, err = db.Exec(context.TODO(), "UPDATE crts SET expiration = $1, authority = $2, rawData = $3, commonName = $4 WHERE id = $5",
-			validTo, issuer, rawData, commonName, d.ID)
+		updated, err := saveTLSCert(d.ID, cert)
 		if err != nil {
 			log.Printf("Failed to update certificate %s: %v\n", d.Domain, err)
 			continue
 		}
+		if d.PerIP {
+			endpoints, err := getIPCerts(endpoint)
+			if err != nil {
+				log.Printf("Failed to check the addresses of %s: %v\n", d.Domain, err)
+			} else if err := saveIPCerts(d.ID, endpoints); err != nil {
+				log.Printf("Failed to update endpoints of %s: %v\n", d.Domain, err)
+			} else {
+				updated.Endpoints = endpoints
+				// Alert once when the addresses start disagreeing
+				if problems := ipCertProblems(endpoints); len(problems) > 0 && len(ipCertProblems(d.Endpoints)) == 0 {
+					ipAlerts = append(ipAlerts, ipCertAlert{Cert: updated, Problems: problems})
+				}
+			}
+		}
+		recordRefresh("updateTLSCerts", auditCert, d.ID, auditCertValue(d), auditCertValue(updated))
+		if validationChanged(d.Validation, cert.Validation) {
+			validationAlerts = append(validationAlerts, validationAlert{Cert: updated})
+		}
+		// Alert once when an intermediate starts expiring before the leaf (a renewal that kept the old intermediate)
+		if problems := chainProblems(updated.Chain); len(problems) > 0 && (d.Chain == nil || len(chainProblems(d.Chain)) == 0) {
+			chainAlerts = append(chainAlerts, chainAlert{Cert: updated, Problems: problems})
+		}
 
 		// TSK: consider for removal
 		log.Printf("Updated certificate: %s\n", d.Domain)
 		time.Sleep(5 * time.Second) // to avoid rate limiting
 	}
+
+	if len(chainAlerts) > 0 {
+		sendChainAlerts(chainAlerts)
+	}
+	if len(ipAlerts) > 0 {
+		sendIPCertAlerts(ipAlerts)
+	}
+	if len(validationAlerts) > 0 {
+		sendValidationAlerts(validationAlerts)
+	}
 }
 
 func sendTLSExpirationReminders() {
@@ -312,6 +493,19 @@ func sendTLSExpirationReminders() {
 	// Array to hold certificates needing reminders
 	var needReminder []TLSDomain
 
+	// Certificates with an intermediate expiring before them, whether or not they expire soon
+	var chainIssues []TLSDomain
+	// Hosts whose addresses serve different certificates
+	var ipIssues []TLSDomain
+	// Certificates that don't validate
+	var invalid []TLSDomain
+
+	// Uploaded CA certificates expire too, everything they signed stops validating
+	caBundles, err := expiringCABundles(time.Now().AddDate(0, 0, getConfig().DaysCertExp))
+	if err != nil {
+		log.Printf("Failed to get CA bundles: %v\n", err)
+	}
+
 	// Populate the array
 	for _, d := range certs {
 		currTime := time.Now().AddDate(0, 0, getConfig().DaysCertExp)
@@ -320,25 +514,80 @@ func sendTLSExpirationReminders() {
 		if currTime.After(d.Expiration) {
 			needReminder = append(needReminder, d)
 		}
+		if len(chainProblems(d.Chain)) > 0 {
+			chainIssues = append(chainIssues, d)
+		}
+		if len(ipCertProblems(d.Endpoints)) > 0 {
+			ipIssues = append(ipIssues, d)
+		}
+		if d.Validation != nil && d.Validation.Status != certValid {
+			invalid = append(invalid, d)
+		}
 	}
 
 	log.Printf("Sending expiration reminder for %d certificates", len(needReminder))
 
 	var certList string
 
-	if len(needReminder) == 0 {
+	if len(needReminder) == 0 && len(chainIssues) == 0 && len(ipIssues) == 0 && len(invalid) == 0 && len(caBundles) == 0 {
 		log.Println("No certificates need reminders, skipping email.")
 		return
 	} else {
 		for _, d := range needReminder {
 			subtitle := fmt.Sprintf("Expires %s &middot; Authority: %s",
 				d.Expiration.Format("01/02/2006"), d.Authority)
-			certList += domainCard("#29a8e1", getConfig().BaseURL+"/dash/tls/?q="+d.CommonName, d.CommonName, subtitle, humanize.Time(d.Expiration))
+			certList += domainCard("#29a8e1", getConfig().BaseURL+"/dash/tls/?q="+certName(d), certName(d), subtitle, humanize.Time(d.Expiration))
+		}
+	}
+
+	title := "TLS certificates expiring soon"
+	var intro string
+	if len(needReminder) > 0 {
+		intro = fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The following %d TLS certificate(s) are expiring within the next <strong>%d days</strong>. Click a certificate to view it in the TLS tracker.</p>`, len(needReminder), getConfig().DaysCertExp)
+	} else if len(invalid) > 0 {
+		title = "Certificate validation problems detected"
+	} else if len(chainIssues) > 0 {
+		title = "Certificate chain problems detected"
+	} else if len(ipIssues) > 0 {
+		title = "Certificate mismatch between endpoints"
+	} else {
+		title = "CA certificates expiring soon"
+	}
+
+	if len(invalid) > 0 {
+		certList += fmt.Sprintf(`<p style="margin:24px 0 20px;font-size:14px;color:#57606a;">The following %d certificate(s) don't validate.</p>`, len(invalid))
+		for _, d := range invalid {
+			subtitle := fmt.Sprintf("Expires %s &middot; Authority: %s", d.Expiration.Format("01/02/2006"), d.Authority)
+			certList += domainCard("#f85149", getConfig().BaseURL+"/dash/tls/?q="+certName(d), certName(d), subtitle,
+				strings.ToUpper(certStatusName(d.Validation.Status))+"<br>"+strings.Join(d.Validation.Problems, "<br>"))
+		}
+	}
+	if len(chainIssues) > 0 {
+		certList += fmt.Sprintf(`<p style="margin:24px 0 20px;font-size:14px;color:#57606a;">The chain of the following %d certificate(s) has an intermediate that expires before the certificate.</p>`, len(chainIssues))
+		for _, d := range chainIssues {
+			subtitle := fmt.Sprintf("Expires %s &middot; Authority: %s", d.Expiration.Format("01/02/2006"), d.Authority)
+			certList += domainCard("#e3b341", getConfig().BaseURL+"/dash/tls/?q="+certName(d), certName(d), subtitle, strings.Join(chainProblems(d.Chain), "<br>"))
+		}
+	}
+	if len(ipIssues) > 0 {
+		certList += fmt.Sprintf(`<p style="margin:24px 0 20px;font-size:14px;color:#57606a;">The addresses of the following %d host(s) serve different certificates.</p>`, len(ipIssues))
+		for _, d := range ipIssues {
+			subtitle := fmt.Sprintf("%s &middot; Authority: %s", d.Domain, d.Authority)
+			certList += domainCard("#e3b341", getConfig().BaseURL+"/dash/tls/?q="+certName(d), certName(d), subtitle, strings.Join(ipCertProblems(d.Endpoints), "<br>"))
+		}
+	}
+
+	if len(caBundles) > 0 {
+		certList += fmt.Sprintf(`<p style="margin:24px 0 20px;font-size:14px;color:#57606a;">The following %d uploaded CA bundle(s) have certificates expiring within the next <strong>%d days</strong>. Upload the renewed CA certificates once they're issued.</p>`, len(caBundles), getConfig().DaysCertExp)
+		for _, b := range caBundles {
+			for _, c := range b.Certs {
+				subtitle := fmt.Sprintf("CA bundle %s &middot; Expires %s", b.Name, c.NotAfter.Format("01/02/2006"))
+				certList += domainCard("#e3b341", getConfig().BaseURL+"/dash/tls/", c.Subject, subtitle, humanize.Time(c.NotAfter))
+			}
 		}
 	}
 
-	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The following %d TLS certificate(s) are expiring within the next <strong>%d days</strong>. Click a certificate to view it in the TLS tracker.</p>`, len(needReminder), getConfig().DaysCertExp)
-	err = sendEmail("TLS certificates expiring soon", emailHTML("TLS certificates expiring soon", intro+certList))
+	err = sendEmail(title, emailHTML(title, intro+certList))
 	if err != nil {
 		log.Printf("TLS: Failed to send expiration reminder email: %v\n", err)
 	}
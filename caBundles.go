@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// parseCABundle parses the certificates of a PEM bundle, anything but certificates is rejected
+func parseCABundle(data string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, errors.New("the bundle may only contain certificates, found " + block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return certs, nil
+}
+
+// caRoots returns the roots to verify a TLS entry with: the system's plus the CA bundles of the entry and its client
+// nil (the system's alone) without bundles
+func caRoots(c TLSDomain) (*x509.CertPool, error) {
+	rows, err := db.Query(context.TODO(), "SELECT pem FROM ca_bundles WHERE certId = $1 OR clientId = $2", c.ID, c.ClientID)
+	if err != nil {
+		return nil, err
+	}
+	bundles, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil || len(bundles) == 0 {
+		return nil, err
+	}
+	// Public certificates of the same client keep validating
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, b := range bundles {
+		pool.AppendCertsFromPEM([]byte(b))
+	}
+	return pool, nil
+}
+
+// trustedEndpoint returns the endpoint of a tracked certificate with the roots it's verified against
+func trustedEndpoint(c TLSDomain) (TLSEndpoint, error) {
+	e := tlsEndpoint(c)
+	roots, err := caRoots(c)
+	e.Roots = roots
+	return e, err
+}
+
+// revalidateCerts refreshes the entries a bundle applies to, so adding or removing it shows right away
+func revalidateCerts(b CABundle) {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM crts WHERE id = $1 OR clientId = $2", b.CertID, b.ClientID)
+	if err != nil {
+		log.Printf("Failed to get certificates: %v\n", err)
+		return
+	}
+	certs, err := pgx.CollectRows(rows, pgx.RowToStructByName[TLSDomain])
+	if err != nil {
+		log.Printf("Failed to collect certificates: %v\n", err)
+		return
+	}
+	for _, c := range certs {
+		endpoint, err := trustedEndpoint(c)
+		if err != nil {
+			log.Printf("Failed to load CA bundles of %s: %v\n", c.Domain, err)
+			continue
+		}
+		data, err := getTLSCert(endpoint)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		updated, err := saveTLSCert(c.ID, data)
+		if err != nil {
+			log.Printf("Failed to update certificate %s: %v\n", c.Domain, err)
+			continue
+		}
+		recordRefresh("caBundle", auditCert, c.ID, auditCertValue(c), auditCertValue(updated))
+	}
+}
+
+// expiringCABundles returns the bundles with certificates expiring before a date, with only those certificates
+func expiringCABundles(before time.Time) ([]CABundle, error) {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM ca_bundles ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	bundles, err := pgx.CollectRows(rows, pgx.RowToStructByName[CABundle])
+	if err != nil {
+		return nil, err
+	}
+	var expiring []CABundle
+	for _, b := range bundles {
+		var certs []ChainCert
+		for _, c := range b.Certs {
+			if c.NotAfter.Before(before) {
+				certs = append(certs, c)
+			}
+		}
+		if len(certs) > 0 {
+			b.Certs = certs
+			expiring = append(expiring, b)
+		}
+	}
+	return expiring, nil
+}
+
+// auditCABundleValue is how a bundle is recorded in the audit log, the certificates are described already
+func auditCABundleValue(b CABundle) CABundle {
+	b.PEM = ""
+	return b
+}
+
+// Handle the /api/caBundleList route, lists the CA bundles (only those of the user's clients for client accounts)
+func caBundleListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.Query(context.TODO(), `SELECT * FROM ca_bundles WHERE $1::int[] IS NULL OR clientId = ANY($1)
+		OR certId IN (SELECT id FROM crts WHERE clientId = ANY($1)) ORDER BY id`, currentUser(r).clientScope())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	bundles, err := pgx.CollectRows(rows, pgx.RowToStructByName[CABundle])
+	if err != nil {
+		http.Error(w, "Error reading CA bundles", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(bundles)
+}
+
+// Handle the /api/caBundleAdd route, the bundle applies to every TLS entry of a client or to a single entry
+func caBundleAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CABundleReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+	if req.Name == "" || req.PEM == "" || (req.ClientID == 0) == (req.CertID == 0) {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	certs, err := parseCABundle(req.PEM)
+	if err != nil {
+		http.Error(w, "Invalid CA bundle: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	described := []ChainCert{}
+	for _, c := range certs {
+		described = append(described, chainCert(c))
+	}
+
+	var clientID, certID *int
+	if req.ClientID != 0 {
+		clientID = &req.ClientID
+	} else {
+		certID = &req.CertID
+	}
+	rows, err := db.Query(context.TODO(), "INSERT INTO ca_bundles (name, clientId, certId, pem, certs) VALUES ($1, $2, $3, $4, $5) RETURNING *",
+		req.Name, clientID, certID, strings.TrimSpace(req.PEM)+"\n", described)
+	if err != nil {
+		http.Error(w, "Failed to add CA bundle", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	added, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[CABundle])
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			http.Error(w, "Client or TLS entry not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to add CA bundle", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditCreate, auditCABundle, added.ID, nil, auditCABundleValue(added))
+	go revalidateCerts(added)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(added)
+}
+
+// Handle the /api/caBundleDelete/:id route
+func caBundleDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract the ID from the URL path
+	// Expected format: /api/caBundleDelete/:id
+	id := strings.Split(r.URL.Path, "/")[3]
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(context.TODO(), "DELETE FROM ca_bundles WHERE id = $1 RETURNING *", id)
+	if err != nil {
+		http.Error(w, "Failed to delete CA bundle", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	deleted, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[CABundle])
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "CA bundle not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete CA bundle", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditDelete, auditCABundle, deleted.ID, auditCABundleValue(deleted), nil)
+	go revalidateCerts(deleted)
+}
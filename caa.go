@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/miekg/dns"
+)
+
+// caaIssuers maps the CAA issuer domain of a CA to the names found in its certificates' issuer (organization or common name)
+var caaIssuers = map[string][]string{
+	"letsencrypt.org": {"let's encrypt"},
+	"pki.goog":        {"google trust services"},
+	"digicert.com":    {"digicert", "geotrust", "rapidssl", "thawte", "encryption everywhere", "cloudflare inc ecc ca"},
+	"sectigo.com":     {"sectigo", "comodo", "usertrust", "zerossl"},
+	"amazon.com":      {"amazon"},
+	"globalsign.com":  {"globalsign"},
+	"godaddy.com":     {"godaddy", "go daddy", "starfield"},
+	"ssl.com":         {"ssl.com"},
+	"buypass.com":     {"buypass"},
+	"entrust.net":     {"entrust"},
+}
+
+// CAA properties a CA has to understand, an unknown one flagged critical forbids issuance (RFC 8659 4.5)
+var caaKnownTags = []string{"issue", "issuewild", "iodef", "issuemail", "issuevmc", "contactemail", "contactphone"}
+
+// lookupCAA finds the CAA record set relevant for a name, climbing to the parent domains until one has records (RFC 8659 3)
+func lookupCAA(name string) (found string, records []*dns.CAA, err error) {
+	labels := dns.SplitDomainName(strings.ToLower(strings.TrimPrefix(name, "*.")))
+	// Stop before the TLD
+	for i := 0; i < len(labels)-1; i++ {
+		n := strings.Join(labels[i:], ".")
+		rrs, err := lookup(n, dns.TypeCAA)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, rr := range rrs {
+			records = append(records, rr.(*dns.CAA))
+		}
+		if len(records) > 0 {
+			return n, records, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// fetchCAAPolicy looks up the CAA policy that applies to a name
+func fetchCAAPolicy(name string) (CAAPolicy, []*dns.CAA, error) {
+	found, records, err := lookupCAA(name)
+	if err != nil {
+		return CAAPolicy{}, nil, err
+	}
+	policy := CAAPolicy{CheckedAt: time.Now(), Name: found, Records: []string{}, Problems: []string{}}
+	for _, rr := range records {
+		policy.Records = append(policy.Records, rrData(rr))
+		if rr.Flag&128 != 0 && !slices.Contains(caaKnownTags, strings.ToLower(rr.Tag)) {
+			policy.Problems = append(policy.Problems, fmt.Sprintf("Unknown critical CAA property %q forbids all issuance", rr.Tag))
+		}
+	}
+	slices.Sort(policy.Records)
+	return policy, records, nil
+}
+
+// caaAllowed lists the CA domains a policy allows to issue, nil means any CA may issue
+func caaAllowed(records []*dns.CAA, wildcard bool) []string {
+	tag := "issue"
+	if wildcard && slices.ContainsFunc(records, func(rr *dns.CAA) bool { return strings.EqualFold(rr.Tag, "issuewild") }) {
+		tag = "issuewild"
+	}
+
+	allowed := []string{}
+	restricted := false
+	for _, rr := range records {
+		if !strings.EqualFold(rr.Tag, tag) {
+			continue
+		}
+		restricted = true
+		// The value is the issuer domain followed by optional parameters, an empty one (";") allows no CA
+		if ca := strings.ToLower(strings.TrimSpace(strings.SplitN(rr.Value, ";", 2)[0])); ca != "" {
+			allowed = append(allowed, ca)
+		}
+	}
+	if !restricted {
+		return nil
+	}
+	return allowed
+}
+
+// certCA returns the CAA issuer domain of the CA that issued a tracked certificate, empty when not known
+func certCA(c TLSDomain) string {
+	var raw struct {
+		Issuer struct {
+			Organization []string
+			CommonName   string
+		}
+	}
+	// The raw data is the JSON of the x509 certificate, the authority column only has the issuer's common name
+	names := strings.ToLower(c.Authority)
+	if err := json.Unmarshal([]byte(c.RawData), &raw); err == nil {
+		names += " " + strings.ToLower(strings.Join(raw.Issuer.Organization, " ")+" "+raw.Issuer.CommonName)
+	}
+	for ca, issuers := range caaIssuers {
+		for _, issuer := range issuers {
+			if strings.Contains(names, issuer) {
+				return ca
+			}
+		}
+	}
+	return ""
+}
+
+// checkCertCAA fetches the CAA policy of a certificate's hostname and checks its CA is still permitted, so renewals don't fail
+func checkCertCAA(c TLSDomain) (CAAPolicy, error) {
+	policy, records, err := fetchCAAPolicy(c.Domain)
+	if err != nil {
+		return CAAPolicy{}, err
+	}
+
+	policy.CA = certCA(c)
+	if policy.CA == "" {
+		return policy, nil
+	}
+	// A wildcard certificate is issued under issuewild, unless the name is also listed on its own
+	wildcard := !slices.Contains(c.DNSNames, strings.ToLower(c.Domain)) &&
+		(strings.HasPrefix(c.CommonName, "*.") || slices.ContainsFunc(c.DNSNames, func(n string) bool { return strings.HasPrefix(n, "*.") }))
+	allowed := caaAllowed(records, wildcard)
+	permitted := len(policy.Problems) == 0 && (allowed == nil || slices.Contains(allowed, policy.CA))
+	policy.Permitted = &permitted
+	if !permitted && len(policy.Problems) == 0 {
+		if len(allowed) == 0 {
+			policy.Problems = append(policy.Problems, fmt.Sprintf("CAA at %s doesn't allow any CA to issue, %s can't renew %s", policy.Name, c.Authority, c.Domain))
+		} else {
+			policy.Problems = append(policy.Problems, fmt.Sprintf("CAA at %s only allows %s, %s (%s) can't renew %s",
+				policy.Name, strings.Join(allowed, ", "), c.Authority, policy.CA, c.Domain))
+		}
+	}
+	return policy, nil
+}
+
+type caaAlert struct {
+	Name    string
+	Link    string
+	Old     *CAAPolicy
+	New     CAAPolicy
+	Changed bool
+}
+
+// caaAlertFor decides whether a new CAA policy is worth an alert: the records changed or problems started
+func caaAlertFor(name, link string, old *CAAPolicy, new CAAPolicy) *caaAlert {
+	changed := old != nil && !slices.Equal(old.Records, new.Records)
+	newProblems := len(new.Problems) > 0 && (old == nil || len(old.Problems) == 0)
+	if !changed && !newProblems {
+		return nil
+	}
+	return &caaAlert{Name: name, Link: link, Old: old, New: new, Changed: changed}
+}
+
+// checkCAA refreshes the CAA policy of every domain and TLS hostname and alerts on changes and certificates their CAA doesn't permit
+func checkCAA() {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains")
+	if err != nil {
+		log.Printf("Failed to get domains: %v\n", err)
+		return
+	}
+	domains, err := pgx.CollectRows(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		log.Printf("Failed to collect domains: %v\n", err)
+		return
+	}
+
+	rows, err = db.Query(context.TODO(), "SELECT * FROM crts")
+	if err != nil {
+		log.Printf("Failed to get certificates: %v\n", err)
+		return
+	}
+	certs, err := pgx.CollectRows(rows, pgx.RowToStructByName[TLSDomain])
+	if err != nil {
+		log.Printf("Failed to collect certificates: %v\n", err)
+		return
+	}
+
+	var alerts []caaAlert
+	for _, d := range domains {
+		policy, _, err := fetchCAAPolicy(d.Domain)
+		if err != nil {
+			log.Printf("Failed to look up CAA for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		if _, err := db.Exec(context.TODO(), "UPDATE domains SET caa = $1 WHERE id = $2", policy, d.ID); err != nil {
+			log.Printf("Failed to save CAA for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		updated := d
+		updated.CAA = &policy
+		recordRefresh("checkCAA", auditDomain, d.ID, auditDomainValue(d), auditDomainValue(updated))
+		if a := caaAlertFor(d.Domain, getConfig().BaseURL+"/dash/?q="+d.Domain, d.CAA, policy); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+
+	for _, c := range certs {
+		policy, err := checkCertCAA(c)
+		if err != nil {
+			log.Printf("Failed to look up CAA for certificate %s: %v\n", c.Domain, err)
+			continue
+		}
+		if _, err := db.Exec(context.TODO(), "UPDATE crts SET caa = $1 WHERE id = $2", policy, c.ID); err != nil {
+			log.Printf("Failed to save CAA for certificate %s: %v\n", c.Domain, err)
+			continue
+		}
+		updated := c
+		updated.CAA = &policy
+		recordRefresh("checkCAA", auditCert, c.ID, auditCertValue(c), auditCertValue(updated))
+		if a := caaAlertFor(c.Domain, getConfig().BaseURL+"/dash/tls/?q="+c.Domain, c.CAA, policy); a != nil {
+			alerts = append(alerts, *a)
+		}
+	}
+
+	if len(alerts) == 0 {
+		log.Println("No CAA changes or problems detected.")
+		return
+	}
+
+	var list string
+	for _, a := range alerts {
+		records := "None (any CA may issue)"
+		if len(a.New.Records) > 0 {
+			records = strings.Join(a.New.Records, "<br>")
+		}
+		color, subtitle := "#e3b341", "CAA records changed"
+		if a.Changed && len(a.Old.Records) > 0 {
+			subtitle += " from " + strings.Join(a.Old.Records, ", ")
+		}
+		if len(a.New.Problems) > 0 {
+			color = "#f85149"
+			records = strings.Join(a.New.Problems, "<br>") + "<br>" + records
+			if !a.Changed {
+				subtitle = "Issuance not permitted"
+			}
+		}
+		list += domainCard(color, a.Link, a.Name, subtitle, records)
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The CAA policy changed or no longer permits the current certificate authority for <strong>%d name(s)</strong>. Certificate renewals fail when the CA isn't allowed to issue.</p>`, len(alerts))
+	if err := sendEmail("CAA policy changes detected", emailHTML("CAA policy changes detected", intro+list)); err != nil {
+		log.Printf("Failed to send CAA alert email: %v\n", err)
+	}
+}
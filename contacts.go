@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"sync"
+
+	whoisparser "github.com/likexian/whois-parser"
+	"github.com/openrdap/rdap"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// Contact privacy states
+const (
+	contactPublic   = "public"
+	contactRedacted = "redacted" // withheld by the registry or registrar (GDPR, RFC 9537)
+	contactPrivacy  = "privacy"  // a privacy/proxy service is listed instead of the real contact
+	contactNone     = "none"     // no such contact in the RDAP/whois data
+)
+
+// Phrases registries and registrars put in place of withheld data
+var redactedMarkers = []string{"redacted", "not disclosed", "data protected", "withheld", "gdpr", "statutory masking", "non-public data", "privacy purposes"}
+
+// Names of privacy/proxy services, their organization is kept so a change of service is still noticed
+var privacyMarkers = []string{"privacy", "proxy", "whoisguard", "identity protect", "domains by proxy", "protected domain"}
+
+// contactState classifies a contact from the values it lists
+func contactState(values ...string) string {
+	state := contactNone
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		lower := strings.ToLower(v)
+		switch {
+		case slices.ContainsFunc(redactedMarkers, func(m string) bool { return strings.Contains(lower, m) }):
+			return contactRedacted
+		case slices.ContainsFunc(privacyMarkers, func(m string) bool { return strings.Contains(lower, m) }):
+			state = contactPrivacy
+		case state == contactNone:
+			state = contactPublic
+		}
+	}
+	return state
+}
+
+// newContact builds a contact, values replaced by a redaction notice are left out
+func newContact(name, org, country, email, phone string) *Contact {
+	c := &Contact{Privacy: contactState(name, org, email)}
+	keep := func(v string) string {
+		if contactState(v) == contactRedacted {
+			return ""
+		}
+		return strings.TrimSpace(v)
+	}
+	c.Name, c.Organization, c.Country, c.Email, c.Phone = keep(name), keep(org), countryCode(keep(country)), strings.ToLower(keep(email)), keep(phone)
+	if c.Privacy == contactNone && (c.Country != "" || c.Phone != "") {
+		c.Privacy = contactPublic
+	}
+	return c
+}
+
+// Country names in English, lowercased, to their ISO 3166 code, with the other names registries use
+var countryCodes = sync.OnceValue(func() map[string]string {
+	codes := map[string]string{
+		"usa": "US", "united states of america": "US", "uk": "GB", "great britain": "GB", "england": "GB",
+		"russian federation": "RU", "korea, republic of": "KR", "republic of korea": "KR", "the netherlands": "NL",
+		"czech republic": "CZ", "viet nam": "VN", "turkey": "TR", "iran, islamic republic of": "IR",
+	}
+	names := display.English.Regions()
+	for a := 'A'; a <= 'Z'; a++ {
+		for b := 'A'; b <= 'Z'; b++ {
+			r, err := language.ParseRegion(string([]rune{a, b}))
+			// Deprecated and reserved codes (UK for GB) are left to the canonical one
+			if err != nil || !r.IsCountry() || r.Canonicalize() != r {
+				continue
+			}
+			if name := names.Name(r); name != "" {
+				codes[strings.ToLower(name)] = r.String()
+			}
+		}
+	}
+	return codes
+})
+
+// countryCode turns a country into its ISO 3166 code, RDAP vCards often spell it out while whois uses the code
+// Unknown names are kept as they are
+func countryCode(country string) string {
+	if len(country) == 2 {
+		if r, err := language.ParseRegion(country); err == nil && r.IsCountry() {
+			return r.Canonicalize().String()
+		}
+	}
+	if code, ok := countryCodes()[strings.ToLower(country)]; ok {
+		return code
+	}
+	return country
+}
+
+// rdapContact extracts a contact from an RDAP entity
+func rdapContact(e *rdap.Entity) *Contact {
+	// RFC 9537 marks removed data in the entity's remarks, some servers drop the vCard entirely
+	redacted := slices.ContainsFunc(e.Remarks, func(r rdap.Remark) bool {
+		return contactState(r.Title) == contactRedacted
+	})
+	if e.VCard == nil {
+		if redacted {
+			return &Contact{Privacy: contactRedacted}
+		}
+		return &Contact{Privacy: contactNone}
+	}
+
+	v := e.VCard
+	var org string
+	if p := v.GetFirst("org"); p != nil {
+		org = strings.Join(p.Values(), " ")
+	}
+	// The country is the last address field, or the cc parameter (ISO code) when the address is a label
+	country := v.Country()
+	if adr := v.GetFirst("adr"); country == "" && adr != nil && len(adr.Parameters["cc"]) > 0 {
+		country = adr.Parameters["cc"][0]
+	}
+	c := newContact(v.Name(), org, country, strings.TrimPrefix(v.Email(), "mailto:"), strings.TrimPrefix(v.Tel(), "tel:"))
+	if redacted && c.Privacy != contactPrivacy {
+		c.Privacy = contactRedacted
+	}
+	return c
+}
+
+// findEntity returns the first entity with a role, searching the entities nested in others too (abuse contacts hang off the registrar)
+func findEntity(entities []rdap.Entity, role string) *rdap.Entity {
+	for i := range entities {
+		if slices.Contains(entities[i].Roles, role) {
+			return &entities[i]
+		}
+	}
+	for i := range entities {
+		if e := findEntity(entities[i].Entities, role); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// rdapContacts extracts the registrant, tech and abuse contacts of an RDAP domain
+func rdapContacts(query *rdap.Domain) DomainContacts {
+	var contacts DomainContacts
+	for role, dst := range map[string]**Contact{"registrant": &contacts.Registrant, "technical": &contacts.Tech, "abuse": &contacts.Abuse} {
+		if e := findEntity(query.Entities, role); e != nil {
+			*dst = rdapContact(e)
+		} else {
+			*dst = &Contact{Privacy: contactNone}
+		}
+	}
+	return contacts
+}
+
+// whoisContacts extracts the registrant, tech and abuse contacts of a parsed whois response
+// The registrar's contact details are its abuse contact (Registrar Abuse Contact Email/Phone)
+func whoisContacts(res whoisparser.WhoisInfo) DomainContacts {
+	contact := func(c *whoisparser.Contact) *Contact {
+		if c == nil {
+			return &Contact{Privacy: contactNone}
+		}
+		return newContact(c.Name, c.Organization, c.Country, c.Email, c.Phone)
+	}
+	abuse := &Contact{Privacy: contactNone}
+	if res.Registrar != nil && (res.Registrar.Email != "" || res.Registrar.Phone != "") {
+		abuse = newContact("", "", "", res.Registrar.Email, res.Registrar.Phone)
+	}
+	return DomainContacts{Registrant: contact(res.Registrant), Tech: contact(res.Technical), Abuse: abuse}
+}
+
+// contactDescription is how a contact reads in an alert
+func contactDescription(c *Contact) string {
+	if c == nil || c.Privacy == contactNone {
+		return "none"
+	}
+	if c.Privacy == contactRedacted {
+		return "redacted"
+	}
+	var parts []string
+	for _, v := range []string{c.Organization, c.Name, c.Email, c.Country} {
+		if v != "" && !slices.Contains(parts, v) {
+			parts = append(parts, v)
+		}
+	}
+	if c.Privacy == contactPrivacy {
+		return "privacy service " + strings.Join(parts, ", ")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// contactChanges compares the contacts stored for a domain with freshly fetched ones, the caller checks they come from the same source (see sameSource)
+// Going from public data to redacted (or back) is reported as such, not as the contact being removed
+func contactChanges(d Domain, contacts DomainContacts) []string {
+	// Not fetched since contacts were tracked
+	if d.RegistrantPrivacy == nil {
+		return nil
+	}
+
+	var changes []string
+	newPrivacy := contacts.Registrant.Privacy
+	if *d.RegistrantPrivacy != newPrivacy {
+		changes = append(changes, fmt.Sprintf("Registrant changed from %s to %s", *d.RegistrantPrivacy, newPrivacy))
+	}
+	// Redacted values are unknown, not changed
+	if *d.RegistrantPrivacy != contactRedacted && newPrivacy != contactRedacted {
+		old, new := deref(d.RegistrantOrg), contacts.Registrant.Organization
+		if old != new {
+			changes = append(changes, fmt.Sprintf("Registrant organization changed from %q to %q", old, new))
+		}
+	}
+	// Countries stored before they were normalized are spelled out
+	if old, new := countryCode(deref(d.RegistrantCountry)), contacts.Registrant.Country; old != "" && new != "" && old != new {
+		changes = append(changes, fmt.Sprintf("Registrant country changed from %s to %s", old, new))
+	}
+
+	for _, c := range []struct {
+		name     string
+		old, new *Contact
+	}{{"Tech contact", d.TechContact, contacts.Tech}, {"Abuse contact", d.AbuseContact, contacts.Abuse}} {
+		if c.old == nil {
+			continue
+		}
+		if c.old.Privacy != c.new.Privacy || c.old.Organization != c.new.Organization || c.old.Email != c.new.Email {
+			changes = append(changes, fmt.Sprintf("%s changed from %s to %s", c.name, contactDescription(c.old), contactDescription(c.new)))
+		}
+	}
+	return changes
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// nilIfEmpty stores an empty string as NULL
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+type contactAlert struct {
+	Domain  Domain
+	Changes []string
+}
+
+func sendContactAlerts(alerts []contactAlert) error {
+	var list string
+	for _, a := range alerts {
+		// Contact names and organizations come straight from RDAP/whois
+		subtitle := "Registrar: " + html.EscapeString(a.Domain.Registrar)
+		list += domainCard("#e3b341", getConfig().BaseURL+"/dash/?q="+a.Domain.Domain, html.EscapeString(a.Domain.Domain), subtitle, htmlLines(a.Changes))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The registrant or contacts of <strong>%d domain(s)</strong> changed. An unexpected registrant change can mean the domain was transferred or hijacked.</p>`, len(alerts))
+	return sendEmail("Domain contact changes detected", emailHTML("Domain contact changes detected", intro+list))
+}
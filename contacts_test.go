@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCountryCode(t *testing.T) {
+	tests := map[string]string{
+		"US":                       "US",
+		"us":                       "US",
+		"United States":            "US",
+		"United States of America": "US",
+		"UNITED KINGDOM":           "GB",
+		"Germany":                  "DE",
+		"Russian Federation":       "RU",
+		"UK":                       "GB",
+		"":                         "",
+		"Atlantis":                 "Atlantis",
+	}
+	for in, want := range tests {
+		if got := countryCode(in); got != want {
+			t.Errorf("countryCode(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestContactChangesCountry(t *testing.T) {
+	public, org, country := contactPublic, "Example Inc", "United States"
+	// Stored from RDAP before countries were normalized
+	d := Domain{RegistrantPrivacy: &public, RegistrantOrg: &org, RegistrantCountry: &country}
+
+	same := DomainContacts{Registrant: newContact("", "Example Inc", "US", "", "")}
+	if got := contactChanges(d, same); len(got) > 0 {
+		t.Errorf("same country reported as changed: %q", got)
+	}
+	moved := DomainContacts{Registrant: newContact("", "Example Inc", "Canada", "", "")}
+	if got, want := contactChanges(d, moved), []string{"Registrant country changed from US to CA"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestContactChangesEscapedInEmail(t *testing.T) {
+	public, org := contactPublic, "Example Inc"
+	d := Domain{RegistrantPrivacy: &public, RegistrantOrg: &org}
+	changes := contactChanges(d, DomainContacts{Registrant: newContact("", `<a href="https://evil.example">Example</a>`, "", "", "")})
+	if len(changes) != 1 {
+		t.Fatalf("changes = %q", changes)
+	}
+	if body := htmlLines(changes); strings.Contains(body, "<a href") {
+		t.Errorf("organization not escaped: %s", body)
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"embed"
+	"fmt"
+	"io/fs"
 	"log"
-	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"golang.org/x/crypto/bcrypt"
@@ -11,96 +16,137 @@ import (
 
 var db *pgxpool.Pool
 
-func InitDBSetup() {
-	// check if the database has already been initialized
-	if _, err := os.Stat(getConfig().DBInitFile); err == nil {
-		// Database has already been initialized
-		return
-	}
+// Schema migrations, named <version>_<description>.sql and applied in version order
+// A migration is never edited once released, schema changes go into a new file
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Arbitrary key for the advisory lock held while migrating, so concurrently starting instances don't race
+const migrationLockKey = 0x646f6d74726b // "domtrk"
 
-	_, err := db.Exec(context.TODO(), `
-		CREATE TABLE IF NOT EXISTS users (
-			id SERIAL PRIMARY KEY,
-			username TEXT NOT NULL UNIQUE,
-			password BYTEA NOT NULL
-		)
-	`)
+const createSchemaMigrations = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		appliedAt TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+`
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations reads the embedded migrations sorted by version
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
 	if err != nil {
-		log.Fatalf("Failed to create users table: %v\n", err)
+		return nil, err
 	}
 
-	_, err = db.Exec(context.TODO(), `
-		CREATE TABLE IF NOT EXISTS sessions (
-			token VARCHAR(48) NOT NULL,
-			userId INTEGER NOT NULL,
-			expires TIMESTAMPTZ NOT NULL,
-			FOREIGN KEY(userId) REFERENCES users(id)
-		)
-	`)
-	if err != nil {
-		log.Fatalf("Failed to create sessions table: %v\n", err)
+	var migrations []migration
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		v, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %s", e.Name())
+		}
+		sql, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: name, SQL: string(sql)})
 	}
 
-	_, err = db.Exec(context.TODO(), `
-		CREATE TABLE IF NOT EXISTS clients (
-			id SERIAL PRIMARY KEY,
-			name TEXT NOT NULL UNIQUE
-		)
-	`)
+	slices.SortFunc(migrations, func(a, b migration) int { return a.Version - b.Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// migrateDB applies every migration not yet recorded in schema_migrations
+func migrateDB() {
+	migrations, err := loadMigrations()
 	if err != nil {
-		log.Fatalf("Failed to create clients table: %v\n", err)
-	}
-
-	_, err = db.Exec(context.TODO(), `
-		CREATE TABLE IF NOT EXISTS domains (
-			id SERIAL PRIMARY KEY,
-			domain TEXT NOT NULL UNIQUE,
-			expiration TIMESTAMPTZ NOT NULL,
-			nameservers JSONB,
-			registrar TEXT NOT NULL,
-			dns JSON NOT NULL,
-			clientId INTEGER NOT NULL,
-			rawWhoisData JSON NOT NULL,
-			notes TEXT,
-			FOREIGN KEY(clientId) REFERENCES clients(id)
-		)
-	`)
+		log.Fatalf("Failed to load migrations: %v\n", err)
+	}
+
+	// Advisory locks belong to a connection, so hold one for the whole run
+	conn, err := db.Acquire(context.TODO())
 	if err != nil {
-		log.Fatalf("Failed to create domains table: %v\n", err)
-	}
-
-	_, err = db.Exec(context.TODO(), `
-		CREATE TABLE IF NOT EXISTS crts (
-			id SERIAL PRIMARY KEY,
-			domain TEXT NOT NULL UNIQUE,
-			commonName TEXT NOT NULL UNIQUE,
-			expiration TIMESTAMPTZ NOT NULL,
-			authority TEXT,
-			clientId INTEGER NOT NULL,
-			rawData TEXT NOT NULL,
-			notes TEXT,
-			FOREIGN KEY(clientId) REFERENCES clients(id)
-		)
-	`)
+		log.Fatalf("Failed to connect to database: %v\n", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(context.TODO(), "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		log.Fatalf("Failed to lock database for migrations: %v\n", err)
+	}
+	defer conn.Exec(context.TODO(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+
+	if _, err := conn.Exec(context.TODO(), createSchemaMigrations); err != nil {
+		log.Fatalf("Failed to create schema_migrations table: %v\n", err)
+	}
+
+	var current int
+	if err := conn.QueryRow(context.TODO(), "SELECT COALESCE(max(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		log.Fatalf("Failed to read schema version: %v\n", err)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		if err := applyMigration(conn, m); err != nil {
+			log.Fatalf("Migration %s failed: %v\n", m.Name, err)
+		}
+		log.Printf("Applied migration %s\n", m.Name)
+	}
+}
+
+// applyMigration runs a migration, it and its schema_migrations row commit together
+func applyMigration(conn *pgxpool.Conn, m migration) error {
+	tx, err := conn.Begin(context.TODO())
 	if err != nil {
-		log.Fatalf("Failed to create crts table: %v\n", err)
+		return err
+	}
+	defer tx.Rollback(context.TODO())
+	if _, err := tx.Exec(context.TODO(), m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(context.TODO(), "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit(context.TODO())
+}
+
+// createInitialUser creates the configured initial user when there are no users yet
+func createInitialUser() {
+	var exists bool
+	if err := db.QueryRow(context.TODO(), "SELECT EXISTS (SELECT 1 FROM users)").Scan(&exists); err != nil {
+		log.Fatalf("Failed to check for users: %v\n", err)
+	}
+	if exists {
+		return
 	}
 
-	// create an initial user
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(getConfig().InitPwd), bcrypt.DefaultCost)
 	if err != nil {
 		log.Fatalln("Failed to hash password:", err)
 	}
 
-	_, err = db.Exec(context.TODO(), "INSERT INTO users (username, password) VALUES ($1, $2)", getConfig().InitUsr, hashedPassword)
+	// The role column defaults to admin
+	_, err = db.Exec(context.TODO(), "INSERT INTO users (username, password) VALUES ($1, $2) ON CONFLICT (username) DO NOTHING", getConfig().InitUsr, hashedPassword)
 	if err != nil {
 		log.Fatalf("Failed to create initial user: %v\n", err)
 	}
-
-	// Create a file to indicate that the database has been initialized
-	if err := os.WriteFile(getConfig().DBInitFile, []byte{}, 0644); err != nil {
-		log.Fatalf("Failed to create db init file (check cfg): %v\n", err)
-	}
+	log.Printf("Created initial user %s\n", getConfig().InitUsr)
 }
 
 func setupDatabase() *pgxpool.Pool {
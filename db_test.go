@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// testDB connects to the PostgreSQL server in TEST_DATABASE_URL with a fresh schema, dropped after the test
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	admin, err := pgx.Connect(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		admin.Close(ctx)
+	})
+
+	conf, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestMigrations(t *testing.T) {
+	pool := testDB(t)
+	ctx := context.Background()
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Up to the data source column, with domains stored from RDAP and whois before it existed
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(ctx, createSchemaMigrations); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.Version > 24 {
+			break
+		}
+		if err := applyMigration(conn, m); err != nil {
+			t.Fatalf("migration %s: %v", m.Name, err)
+		}
+	}
+	conn.Release()
+	_, err = pool.Exec(ctx, `INSERT INTO clients (name) VALUES ('client');
+		INSERT INTO domains (domain, expiration, registrar, dns, clientId, rawWhoisData) VALUES
+			('rdap.example', now(), 'Registrar', '{}', 1, '{"ObjectClassName":"domain","LDHName":"rdap.example"}'),
+			('whois.example', now(), 'Registrar', '{}', 1, '{"domain":{"domain":"whois.example"}}')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rest as the app applies them, twice to check a second start is a no-op
+	db = pool
+	t.Cleanup(func() { db = nil })
+	migrateDB()
+	migrateDB()
+
+	var applied int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations recorded, want %d", applied, len(migrations))
+	}
+	for domain, want := range map[string]string{"rdap.example": sourceRDAP, "whois.example": sourceWhois} {
+		var source *string
+		if err := pool.QueryRow(ctx, "SELECT dataSource FROM domains WHERE domain = $1", domain).Scan(&source); err != nil {
+			t.Fatal(err)
+		}
+		if deref(source) != want {
+			t.Errorf("%s: dataSource = %q, want %q", domain, deref(source), want)
+		}
+	}
+
+	// A host:port is unique per SNI name, no SNI counting as one
+	a, b := "a.example", "b.example"
+	insertCert := `INSERT INTO crts (domain, commonName, expiration, clientId, rawData, port, sni) VALUES ('mail.example', 'mail.example', now(), 1, '', 443, $1)`
+	for _, sni := range []*string{nil, &a, &b} {
+		if _, err := pool.Exec(ctx, insertCert, sni); err != nil {
+			t.Fatalf("SNI %q: %v", deref(sni), err)
+		}
+	}
+	for _, sni := range []*string{nil, &a} {
+		if _, err := pool.Exec(ctx, insertCert, sni); !isUniqueViolation(err) {
+			t.Errorf("duplicate endpoint with SNI %q: err = %v, want a unique violation", deref(sni), err)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Record types compared between snapshots, and the ones that trigger an alert when they change
+// (NS changes are alerted by detectNameserverChanges)
+var (
+	dnsRecordTypes  = []string{"A", "AAAA", "MX", "NS"}
+	dnsAlertedTypes = []string{"A", "AAAA", "MX"}
+)
+
+// dnsRecords returns the records of one type
+func dnsRecords(d DNS, recordType string) []string {
+	switch recordType {
+	case "A":
+		return d.A
+	case "AAAA":
+		return d.AAAA
+	case "MX":
+		return d.MX
+	case "NS":
+		return d.NS
+	}
+	return nil
+}
+
+// diffDNS lists the records added and removed per type (only types that changed)
+func diffDNS(old, new DNS) []DNSRecordChange {
+	changes := []DNSRecordChange{}
+	for _, t := range dnsRecordTypes {
+		oldRecords, newRecords := dnsRecords(old, t), dnsRecords(new, t)
+		change := DNSRecordChange{Type: t, Added: []string{}, Removed: []string{}}
+		for _, r := range newRecords {
+			if !slices.Contains(oldRecords, r) {
+				change.Added = append(change.Added, r)
+			}
+		}
+		for _, r := range oldRecords {
+			if !slices.Contains(newRecords, r) {
+				change.Removed = append(change.Removed, r)
+			}
+		}
+		if len(change.Added) > 0 || len(change.Removed) > 0 {
+			change.Added, change.Removed = slices.Sorted(slices.Values(change.Added)), slices.Sorted(slices.Values(change.Removed))
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
+
+func saveDNSSnapshot(domainID int, dns DNS, changed bool) error {
+	_, err := db.Exec(context.TODO(), "INSERT INTO dns_snapshots (domainId, dns, changed) VALUES ($1, $2, $3)", domainID, dns, changed)
+	return err
+}
+
+type dnsChangeAlert struct {
+	Domain  Domain
+	Changes []DNSRecordChange
+}
+
+// recordDNS stores a snapshot of a domain's freshly resolved records and saves them on the domain when they changed
+// Every job that resolves a domain's records goes through here, so none of them overwrites a change before it's diffed
+func recordDNS(d Domain, dns DNS, job string) (*dnsChangeAlert, *mailSecurityAlert, error) {
+	keepFailedMailChecks(d.DNS.Mail, dns.Mail)
+	changes := diffDNS(d.DNS, dns)
+	changed := len(changes) > 0 || mailSecurityChanged(d.DNS.Mail, dns.Mail)
+	if err := saveDNSSnapshot(d.ID, dns, changed); err != nil {
+		return nil, nil, err
+	}
+	var mailAlert *mailSecurityAlert
+	if weakened := mailSecurityWeakened(d.DNS.Mail, dns.Mail); len(weakened) > 0 {
+		mailAlert = &mailSecurityAlert{Domain: d.Domain, Grade: dns.Mail.Grade, Changes: weakened}
+	}
+	if !changed {
+		return nil, mailAlert, nil
+	}
+
+	if _, err := db.Exec(context.TODO(), "UPDATE domains SET dns = $1 WHERE id = $2", dns, d.ID); err != nil {
+		return nil, mailAlert, err
+	}
+	updated := d
+	updated.DNS = dns
+	recordAudit(systemActor(job), auditUpdate, auditDomain, d.ID, auditDomainValue(d), auditDomainValue(updated))
+
+	alerted := slices.DeleteFunc(changes, func(c DNSRecordChange) bool { return !slices.Contains(dnsAlertedTypes, c.Type) })
+	if len(alerted) == 0 {
+		return nil, mailAlert, nil
+	}
+	return &dnsChangeAlert{Domain: d, Changes: alerted}, mailAlert, nil
+}
+
+// takeDNSSnapshots resolves the DNS records of every domain, stores a snapshot and alerts when A/AAAA/MX records changed
+func takeDNSSnapshots() {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains")
+	if err != nil {
+		log.Printf("Failed to get domains: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	domains, err := pgx.CollectRows(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		log.Printf("Failed to collect domains: %v\n", err)
+		return
+	}
+
+	var alerts []dnsChangeAlert
+	var mailAlerts []mailSecurityAlert
+	for _, d := range domains {
+		// Don't record records as removed when their lookup failed
+		dns, err := resolveDomainDNS(d.Domain)
+		if err != nil {
+			log.Printf("Failed to resolve DNS for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+
+		alert, mailAlert, err := recordDNS(d, dns, "takeDNSSnapshots")
+		if mailAlert != nil {
+			mailAlerts = append(mailAlerts, *mailAlert)
+		}
+		if err != nil {
+			log.Printf("Failed to save DNS snapshot for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		if alert != nil {
+			alerts = append(alerts, *alert)
+		}
+
+		time.Sleep(1 * time.Second) // avoid rate limiting the resolver
+	}
+
+	if len(mailAlerts) > 0 {
+		if err := sendMailSecurityAlerts(mailAlerts); err != nil {
+			log.Printf("Failed to send email security alert email: %v\n", err)
+		}
+	}
+
+	if len(alerts) == 0 {
+		log.Println("No DNS record changes detected.")
+		return
+	}
+	sendDNSChangeAlerts(alerts)
+}
+
+func sendDNSChangeAlerts(alerts []dnsChangeAlert) {
+	var listChanges string
+	for _, a := range alerts {
+		var details string
+		for _, c := range a.Changes {
+			if len(c.Removed) > 0 {
+				details += fmt.Sprintf(`<tr><td style="padding-right:12px;white-space:nowrap;color:#6e7681;">%s removed</td><td>%s</td></tr>`, c.Type, strings.Join(c.Removed, ", "))
+			}
+			if len(c.Added) > 0 {
+				details += fmt.Sprintf(`<tr><td style="padding-right:12px;white-space:nowrap;color:#6e7681;">%s added</td><td style="color:#29a8e1;">%s</td></tr>`, c.Type, strings.Join(c.Added, ", "))
+			}
+		}
+		subtitle := fmt.Sprintf("Detected %s", time.Now().Format("01/02/2006 @ 03:04:05PM"))
+		listChanges += domainCard("#e3b341", getConfig().BaseURL+"/dash/?q="+a.Domain.Domain, a.Domain.Domain, subtitle, "") +
+			`<table cellpadding="0" cellspacing="0" style="margin-top:8px;font-size:13px;color:#57606a;">` + details + `</table>`
+	}
+
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">A, AAAA or MX record changes were detected for <strong>%d domain(s)</strong>. If a change wasn't expected, investigate it right away.</p>`, len(alerts))
+	if err := sendEmail("DNS record changes detected", emailHTML("DNS record changes detected", intro+listChanges)); err != nil {
+		log.Printf("Failed to send DNS change alert email: %v\n", err)
+	}
+}
+
+// domainInScope reports whether a domain exists and the user may see it
+func domainInScope(user AuthUser, id any) (bool, error) {
+	var exists bool
+	err := db.QueryRow(context.TODO(), "SELECT EXISTS (SELECT 1 FROM domains WHERE id = $1 AND ($2::int[] IS NULL OR clientId = ANY($2)))",
+		id, user.clientScope()).Scan(&exists)
+	return exists, err
+}
+
+// Handle the /api/dnsSnapshots/:id route, lists a domain's DNS snapshots (newest first)
+// Filters: ?changed=true (only snapshots that differ from the previous one), ?limit= (default 100)
+func dnsSnapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract the ID from the URL path
+	// Expected format: /api/dnsSnapshots/:id
+	id := strings.Split(r.URL.Path, "/")[3]
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ok, err := domainInScope(currentUser(r), id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Query(context.TODO(), "SELECT id, domainId, takenAt, dns, changed FROM dns_snapshots WHERE domainId = $1 AND (changed OR NOT $2) ORDER BY takenAt DESC, id DESC LIMIT $3",
+		id, r.URL.Query().Get("changed") == "true", limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	snapshots, err := pgx.CollectRows(rows, pgx.RowToStructByName[DNSSnapshot])
+	if err != nil {
+		http.Error(w, "Error reading DNS snapshots", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+// Handle the /api/dnsDiff route, compares two DNS snapshots of a domain
+// ?domainID= is required, ?to= defaults to the latest snapshot and ?from= to the one before it
+func dnsDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	domainID, err := strconv.Atoi(q.Get("domainID"))
+	if err != nil {
+		http.Error(w, "Missing or invalid domainID", http.StatusBadRequest)
+		return
+	}
+	var from, to *int
+	for name, dst := range map[string]**int{"from": &from, "to": &to} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = &id
+		}
+	}
+
+	ok, err := domainInScope(currentUser(r), domainID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if !ok {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+
+	diff := DNSDiff{DomainID: domainID}
+	rows, err := db.Query(context.TODO(), "SELECT id, domainId, takenAt, dns, changed FROM dns_snapshots WHERE domainId = $1 AND ($2::int IS NULL OR id = $2) ORDER BY takenAt DESC, id DESC LIMIT 1",
+		domainID, to)
+	if err == nil {
+		diff.To, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[DNSSnapshot])
+	}
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	// Without ?from= compare with the snapshot taken before, if any
+	rows, err = db.Query(context.TODO(), `
+		SELECT id, domainId, takenAt, dns, changed FROM dns_snapshots
+		WHERE domainId = $1 AND CASE WHEN $2::int IS NULL THEN (takenAt, id) < ($3, $4) ELSE id = $2 END
+		ORDER BY takenAt DESC, id DESC LIMIT 1`,
+		domainID, from, diff.To.TakenAt, diff.To.ID)
+	if err == nil {
+		var prev DNSSnapshot
+		prev, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[DNSSnapshot])
+		if err == nil {
+			diff.From = &prev
+		}
+	}
+	if err != nil && !(err == pgx.ErrNoRows && from == nil) {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	if diff.From != nil {
+		diff.Changes = diffDNS(diff.From.DNS, diff.To.DNS)
+	} else {
+		diff.Changes = diffDNS(DNS{}, diff.To.DNS)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
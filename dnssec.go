@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/miekg/dns"
+	"github.com/openrdap/rdap"
+)
+
+// DNSSEC states
+const (
+	dnssecUnsigned = "unsigned" // no DS and no DNSKEY
+	dnssecInsecure = "insecure" // the zone is signed but the parent has no DS, so nothing validates it
+	dnssecSecure   = "secure"
+	dnssecBogus    = "bogus" // validating resolvers will fail to resolve the domain
+)
+
+// Signatures expiring sooner than this are reported, the signer has most likely stopped re-signing the zone
+const dnssecSigWarning = 24 * time.Hour
+
+// rdapDSRecords converts the dsData of an RDAP secureDNS object, an unsigned delegation has none
+func rdapDSRecords(s *rdap.SecureDNS) []DSRecord {
+	records := []DSRecord{}
+	for _, ds := range s.DS {
+		if ds.KeyTag == nil || ds.Algorithm == nil || ds.DigestType == nil {
+			continue
+		}
+		records = append(records, DSRecord{
+			KeyTag:     uint16(*ds.KeyTag),
+			Algorithm:  *ds.Algorithm,
+			DigestType: *ds.DigestType,
+			Digest:     strings.ToUpper(ds.Digest),
+		})
+	}
+	return records
+}
+
+// parentDSRecords looks the DS records up in DNS, for domains the registry didn't report them for (whois)
+func parentDSRecords(domain string) ([]DSRecord, error) {
+	rrs, err := lookup(domain, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	records := []DSRecord{}
+	for _, rr := range rrs {
+		ds := rr.(*dns.DS)
+		records = append(records, DSRecord{KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType, Digest: strings.ToUpper(ds.Digest)})
+	}
+	return records, nil
+}
+
+// matchesDS reports whether a DNSKEY hashes to the digest of a DS record
+func matchesDS(key *dns.DNSKEY, ds DSRecord) bool {
+	if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+		return false
+	}
+	computed := key.ToDS(ds.DigestType)
+	return computed != nil && strings.EqualFold(computed.Digest, ds.Digest)
+}
+
+// querySigned asks an authoritative nameserver for an RRset and the signatures covering it
+func querySigned(ip, name string, qtype uint16) (rrset []dns.RR, sigs []*dns.RRSIG, err error) {
+	msg, err := queryAuthoritative(ip, name, qtype)
+	if err != nil {
+		return nil, nil, err
+	}
+	if msg.Rcode != dns.RcodeSuccess {
+		return nil, nil, fmt.Errorf("server answered %s", dns.RcodeToString[msg.Rcode])
+	}
+	for _, rr := range msg.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == qtype {
+			sigs = append(sigs, sig)
+		} else if rr.Header().Rrtype == qtype {
+			rrset = append(rrset, rr)
+		}
+	}
+	return rrset, sigs, nil
+}
+
+// verifySigs checks the signatures of an RRset against the keys, it returns the keys that made a valid signature
+func verifySigs(status *DNSSECStatus, rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY) []*dns.DNSKEY {
+	var signers []*dns.DNSKEY
+	for _, sig := range sigs {
+		info := RRSIGInfo{
+			Covers:     dns.TypeToString[sig.TypeCovered],
+			KeyTag:     sig.KeyTag,
+			Algorithm:  sig.Algorithm,
+			Inception:  time.Unix(int64(sig.Inception), 0).UTC(),
+			Expiration: time.Unix(int64(sig.Expiration), 0).UTC(),
+		}
+
+		i := slices.IndexFunc(keys, func(k *dns.DNSKEY) bool { return k.KeyTag() == sig.KeyTag && k.Algorithm == sig.Algorithm })
+		if i < 0 {
+			info.Error = "no DNSKEY with this key tag"
+		} else if err := sig.Verify(keys[i], rrset); err != nil {
+			info.Error = err.Error()
+		} else if !sig.ValidityPeriod(time.Now()) {
+			info.Error = "outside its validity period"
+		} else {
+			signers = append(signers, keys[i])
+		}
+		status.Signatures = append(status.Signatures, info)
+
+		if info.Error == "" && (status.SignatureExpiration == nil || info.Expiration.Before(*status.SignatureExpiration)) {
+			status.SignatureExpiration = &info.Expiration
+		}
+	}
+	return signers
+}
+
+// checkDomainDNSSEC validates the chain from the domain's DS records to the DNSKEY set and the zone's SOA signature
+// An error means the check couldn't be done (lookup failures), not that DNSSEC is broken
+func checkDomainDNSSEC(d Domain) (DNSSECStatus, error) {
+	status := DNSSECStatus{CheckedAt: time.Now(), DSSource: "rdap", DS: d.DS, Keys: []DNSKEYInfo{}, Signatures: []RRSIGInfo{}, Problems: []string{}}
+	if d.DS == nil {
+		ds, err := parentDSRecords(d.Domain)
+		if err != nil {
+			return DNSSECStatus{}, err
+		}
+		status.DS, status.DSSource = ds, "dns"
+	}
+
+	// Ask the first authoritative nameserver that answers, a resolver might strip the signatures
+	var keyRRs []dns.RR
+	var keySigs []*dns.RRSIG
+	var ip string
+	var errs []error
+	for _, host := range TrimDot(d.Nameservers) {
+		addr, err := nameserverIP(host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		keyRRs, keySigs, err = querySigned(addr, d.Domain, dns.TypeDNSKEY)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		ip, status.Server = addr, host
+		break
+	}
+	if status.Server == "" {
+		return DNSSECStatus{}, fmt.Errorf("no nameserver answered the DNSKEY query: %w", errors.Join(errs...))
+	}
+
+	if len(keyRRs) == 0 {
+		if len(status.DS) == 0 {
+			status.State = dnssecUnsigned
+		} else {
+			status.State = dnssecBogus
+			status.Problems = append(status.Problems, "DS records are published but the zone has no DNSKEY records")
+		}
+		return status, nil
+	}
+
+	keys := make([]*dns.DNSKEY, 0, len(keyRRs))
+	var dsKeys []*dns.DNSKEY
+	for _, rr := range keyRRs {
+		key := rr.(*dns.DNSKEY)
+		keys = append(keys, key)
+		info := DNSKEYInfo{KeyTag: key.KeyTag(), Algorithm: key.Algorithm, Flags: key.Flags}
+		info.DS = slices.ContainsFunc(status.DS, func(ds DSRecord) bool { return matchesDS(key, ds) })
+		if info.DS {
+			dsKeys = append(dsKeys, key)
+		}
+		status.Keys = append(status.Keys, info)
+	}
+
+	var failed []string
+	if len(status.DS) > 0 && len(dsKeys) == 0 {
+		var tags []string
+		for _, ds := range status.DS {
+			tags = append(tags, fmt.Sprint(ds.KeyTag))
+		}
+		failed = append(failed, fmt.Sprintf("No DNSKEY matches the DS records (key tag %s)", strings.Join(tags, ", ")))
+	}
+
+	// The DNSKEY set has to be signed by a key the DS records point to
+	signers := verifySigs(&status, keyRRs, keySigs, keys)
+	if len(dsKeys) > 0 && !slices.ContainsFunc(signers, func(k *dns.DNSKEY) bool { return slices.Contains(dsKeys, k) }) {
+		failed = append(failed, "The DNSKEY set isn't validly signed by a key matching the DS records")
+	}
+	if len(signers) == 0 {
+		failed = append(failed, "The DNSKEY set has no valid signature")
+	}
+
+	// The zone data is signed with any key of the set (usually the ZSK)
+	soaRRs, soaSigs, err := querySigned(ip, d.Domain, dns.TypeSOA)
+	if err != nil {
+		return DNSSECStatus{}, fmt.Errorf("SOA query to %s failed: %w", status.Server, err)
+	}
+	if len(verifySigs(&status, soaRRs, soaSigs, keys)) == 0 {
+		failed = append(failed, "The SOA record has no valid signature")
+	}
+
+	switch {
+	case len(status.DS) == 0:
+		status.State = dnssecInsecure
+	case len(failed) > 0:
+		status.State = dnssecBogus
+		status.Problems = append(status.Problems, failed...)
+	default:
+		status.State = dnssecSecure
+	}
+
+	// Signatures about to run out will make a secure zone bogus
+	if status.State == dnssecSecure && status.SignatureExpiration != nil && time.Until(*status.SignatureExpiration) < dnssecSigWarning {
+		status.Problems = append(status.Problems, fmt.Sprintf("Signatures expire %s, the zone isn't being re-signed",
+			status.SignatureExpiration.Format("01/02/2006 15:04 MST")))
+	}
+	return status, nil
+}
+
+type dnssecAlert struct {
+	Domain Domain
+	Status DNSSECStatus
+}
+
+// checkDNSSEC validates DNSSEC for every domain, stores the result and alerts when a domain runs into problems
+func checkDNSSEC() {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains")
+	if err != nil {
+		log.Printf("Failed to get domains: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	domains, err := pgx.CollectRows(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		log.Printf("Failed to collect domains: %v\n", err)
+		return
+	}
+
+	var alerts []dnssecAlert
+	for _, d := range domains {
+		if len(d.Nameservers) == 0 {
+			continue
+		}
+
+		status, err := checkDomainDNSSEC(d)
+		if err != nil {
+			log.Printf("Failed to check DNSSEC for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		if _, err := db.Exec(context.TODO(), "UPDATE domains SET dnssec = $1 WHERE id = $2", status, d.ID); err != nil {
+			log.Printf("Failed to save DNSSEC status for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		updated := d
+		updated.DNSSEC = &status
+		recordRefresh("checkDNSSEC", auditDomain, d.ID, auditDomainValue(d), auditDomainValue(updated))
+
+		// Only alert when the problems start, the weekly reminder repeats them until they're fixed
+		if len(status.Problems) > 0 && (d.DNSSEC == nil || len(d.DNSSEC.Problems) == 0) {
+			alerts = append(alerts, dnssecAlert{Domain: d, Status: status})
+		}
+	}
+
+	if len(alerts) == 0 {
+		log.Println("No new DNSSEC problems detected.")
+		return
+	}
+
+	var list string
+	for _, a := range alerts {
+		subtitle := fmt.Sprintf("State: %s &middot; Checked via %s", a.Status.State, a.Status.Server)
+		list += domainCard("#f85149", getConfig().BaseURL+"/dash/?q="+a.Domain.Domain, a.Domain.Domain, subtitle, strings.Join(a.Status.Problems, "<br>"))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">DNSSEC problems were detected for <strong>%d domain(s)</strong>. Validating resolvers fail to resolve a domain with a broken chain, fix it right away.</p>`, len(alerts))
+	if err := sendEmail("DNSSEC problems detected", emailHTML("DNSSEC problems detected", intro+list)); err != nil {
+		log.Printf("Failed to send DNSSEC alert email: %v\n", err)
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DKIM selectors tried when none are configured
+var defaultDKIMSelectors = []string{"default", "google", "selector1", "selector2", "k1", "s1", "s2", "dkim"}
+
+// RFC 7208 4.6.4: at most 10 mechanisms/modifiers that need a DNS lookup
+const spfMaxLookups = 10
+
+// txtRecords returns the TXT records of a name, each record's strings joined
+func txtRecords(name string) ([]string, error) {
+	rrs, err := lookup(name, dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		records = append(records, strings.Join(rr.(*dns.TXT).Txt, ""))
+	}
+	return records, nil
+}
+
+// txtWithPrefix returns the TXT records of a name that start with prefix (case insensitive)
+func txtWithPrefix(name, prefix string) ([]string, error) {
+	records, err := txtRecords(name)
+	if err != nil {
+		return nil, err
+	}
+	var matching []string
+	for _, r := range records {
+		if len(r) >= len(prefix) && strings.EqualFold(r[:len(prefix)], prefix) {
+			matching = append(matching, r)
+		}
+	}
+	return matching, nil
+}
+
+// parseTags parses "k=v; k=v" records (DMARC, DKIM, MTA-STS, TLS-RPT)
+func parseTags(record string) map[string]string {
+	tags := map[string]string{}
+	for _, part := range strings.Split(record, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return tags
+}
+
+// checkSPF looks up and validates the SPF record, counting its DNS lookups
+func checkSPF(domain string) (*SPFResult, error) {
+	records, err := txtWithPrefix(domain, "v=spf1")
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	res := &SPFResult{Record: records[0]}
+	if len(records) > 1 {
+		res.Errors = append(res.Errors, "multiple SPF records")
+	}
+	for _, term := range strings.Fields(records[0])[1:] {
+		if t := strings.ToLower(term); strings.TrimLeft(t, "+-~?") == "all" {
+			res.All = strings.TrimSuffix(t, "all")
+			if res.All == "" {
+				res.All = "+"
+			}
+		}
+	}
+
+	lookups, errs, err := spfLookups(records[0], map[string]bool{domain: true})
+	if err != nil {
+		return nil, err
+	}
+	res.Lookups = lookups
+	res.Errors = append(res.Errors, errs...)
+	if lookups > spfMaxLookups {
+		res.Errors = append(res.Errors, fmt.Sprintf("%d DNS lookups (the limit is %d)", lookups, spfMaxLookups))
+	}
+	return res, nil
+}
+
+// spfLookups counts the DNS lookups an SPF record causes, following include: and redirect=
+// Problems with the record are returned as strings, the error is for failed lookups
+func spfLookups(record string, seen map[string]bool) (int, []string, error) {
+	var count int
+	var errs []string
+	for _, term := range strings.Fields(record)[1:] {
+		term = strings.ToLower(strings.TrimLeft(term, "+-~?"))
+		name, target, _ := strings.Cut(term, ":")
+		if strings.HasPrefix(term, "redirect=") {
+			name, target = "redirect", strings.TrimPrefix(term, "redirect=")
+		}
+		name, _, _ = strings.Cut(name, "/")
+
+		switch name {
+		case "a", "mx", "ptr", "exists":
+			count++
+		case "include", "redirect":
+			count++
+			// Macros are expanded per message, they can't be followed here
+			if strings.Contains(target, "%") {
+				continue
+			}
+			if seen[target] {
+				errs = append(errs, fmt.Sprintf("%s:%s is a loop", name, target))
+				continue
+			}
+			// Stop early, the record is broken anyway
+			if count > spfMaxLookups {
+				return count, errs, nil
+			}
+			seen[target] = true
+			records, err := txtWithPrefix(target, "v=spf1")
+			if err != nil {
+				return 0, nil, err
+			}
+			if len(records) == 0 {
+				errs = append(errs, fmt.Sprintf("%s:%s has no SPF record", name, target))
+				continue
+			}
+			n, e, err := spfLookups(records[0], seen)
+			if err != nil {
+				return 0, nil, err
+			}
+			count += n
+			errs = append(errs, e...)
+		}
+	}
+	return count, errs, nil
+}
+
+// checkDMARC looks up the DMARC policy
+func checkDMARC(domain string) (*DMARCResult, error) {
+	records, err := txtWithPrefix("_dmarc."+domain, "v=DMARC1")
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	tags := parseTags(records[0])
+	res := &DMARCResult{Record: records[0], Policy: strings.ToLower(tags["p"]), SubdomainPolicy: strings.ToLower(tags["sp"]), Pct: 100}
+	if pct, err := strconv.Atoi(tags["pct"]); err == nil {
+		res.Pct = pct
+	}
+	for _, uri := range strings.Split(tags["rua"], ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			res.RUA = append(res.RUA, uri)
+		}
+	}
+	return res, nil
+}
+
+// checkDKIM looks up the DKIM keys of the given selectors, only found keys are returned
+func checkDKIM(domain string, selectors []string) ([]DKIMResult, error) {
+	var results []DKIMResult
+	for _, selector := range selectors {
+		records, err := txtRecords(selector + "._domainkey." + domain)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			tags := parseTags(r)
+			p, ok := tags["p"]
+			if !ok {
+				continue
+			}
+			res := DKIMResult{Selector: selector, KeyType: strings.ToLower(tags["k"]), Revoked: p == ""}
+			if res.KeyType == "" {
+				res.KeyType = "rsa"
+			}
+			if !res.Revoked {
+				res.Bits = dkimKeyBits(res.KeyType, p)
+			}
+			results = append(results, res)
+			break
+		}
+	}
+	return results, nil
+}
+
+// dkimKeyBits returns the size of a DKIM public key (0 if it can't be parsed)
+func dkimKeyBits(keyType, p string) int {
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(p), ""))
+	if err != nil {
+		return 0
+	}
+	if keyType == "ed25519" {
+		return len(der) * 8
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		// Some keys are published as a bare PKCS #1 RSA key
+		if rsaKey, err := x509.ParsePKCS1PublicKey(der); err == nil {
+			return rsaKey.N.BitLen()
+		}
+		return 0
+	}
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case ed25519.PublicKey:
+		return len(key) * 8
+	}
+	return 0
+}
+
+// checkMTASTS looks up the MTA-STS record and fetches its policy
+func checkMTASTS(domain string) (*MTASTSResult, error) {
+	records, err := txtWithPrefix("_mta-sts."+domain, "v=STSv1")
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	res := &MTASTSResult{Record: records[0], ID: parseTags(records[0])["id"]}
+	if err := fetchMTASTSPolicy(domain, res); err != nil {
+		res.Error = err.Error()
+	}
+	return res, nil
+}
+
+// fetchMTASTSPolicy fetches and parses https://mta-sts.<domain>/.well-known/mta-sts.txt (RFC 8461 3.2)
+func fetchMTASTSPolicy(domain string, res *MTASTSResult) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		// Redirects must not be followed
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get("https://mta-sts." + domain + "/.well-known/mta-sts.txt")
+	if err != nil {
+		return fmt.Errorf("failed to fetch policy: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch policy: %s", resp.Status)
+	}
+
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 64*1024))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.TrimSpace(k) {
+		case "mode":
+			res.Mode = v
+		case "mx":
+			res.MX = append(res.MX, v)
+		case "max_age":
+			res.MaxAge, _ = strconv.Atoi(v)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read policy: %w", err)
+	}
+	if res.Mode == "" {
+		return errors.New("policy has no mode")
+	}
+	return nil
+}
+
+// checkTLSRPT looks up the SMTP TLS reporting record
+func checkTLSRPT(domain string) (*TLSRPTResult, error) {
+	records, err := txtWithPrefix("_smtp._tls."+domain, "v=TLSRPTv1")
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	res := &TLSRPTResult{Record: records[0]}
+	for _, uri := range strings.Split(parseTags(records[0])["rua"], ",") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			res.RUA = append(res.RUA, uri)
+		}
+	}
+	return res, nil
+}
+
+// checkMailSecurity runs all email authentication checks and grades the result
+// A check whose lookup fails is listed in Failed rather than reported as a missing record, the others still run
+func checkMailSecurity(domain string) *MailSecurity {
+	selectors := getConfig().DKIMSelectors
+	if len(selectors) == 0 {
+		selectors = defaultDKIMSelectors
+	}
+
+	m := &MailSecurity{}
+	failed := func(check string, err error) {
+		log.Printf("%s check of %s failed: %v\n", check, domain, err)
+		m.Failed = append(m.Failed, check)
+	}
+	var err error
+	if m.SPF, err = checkSPF(domain); err != nil {
+		failed("SPF", err)
+	}
+	if m.DMARC, err = checkDMARC(domain); err != nil {
+		failed("DMARC", err)
+	}
+	if m.DKIM, err = checkDKIM(domain, selectors); err != nil {
+		failed("DKIM", err)
+	}
+	if m.MTASTS, err = checkMTASTS(domain); err != nil {
+		failed("MTA-STS", err)
+	}
+	if m.TLSRPT, err = checkTLSRPT(domain); err != nil {
+		failed("TLS-RPT", err)
+	}
+
+	gradeMailSecurity(m)
+	return m
+}
+
+// Strength of policies, higher is stricter
+var (
+	spfAllStrength = map[string]int{"-": 3, "~": 2, "?": 1, "+": 0, "": 0}
+	dmarcStrength  = map[string]int{"reject": 3, "quarantine": 2, "none": 1, "": 0}
+	mtaSTSStrength = map[string]int{"enforce": 3, "testing": 2, "none": 1, "": 0}
+)
+
+// gradeMailSecurity scores the checks (out of 100), sets the grade and lists the issues
+// A failed check is graded on the result kept from the previous check, or not at all without one
+func gradeMailSecurity(m *MailSecurity) {
+	score := 0
+	m.Issues = nil
+	failed := func(check string) bool { return slices.Contains(m.Failed, check) }
+	for _, check := range m.Failed {
+		m.Issues = append(m.Issues, check+" lookup failed")
+	}
+
+	switch {
+	case m.SPF == nil && failed("SPF"):
+	case m.SPF == nil:
+		m.Issues = append(m.Issues, "No SPF record")
+	case len(m.SPF.Errors) > 0:
+		m.Issues = append(m.Issues, "SPF: "+strings.Join(m.SPF.Errors, ", "))
+		score += 10
+	case spfAllStrength[m.SPF.All] < 2:
+		m.Issues = append(m.Issues, "SPF doesn't end in -all or ~all")
+		score += 10
+	default:
+		score += 25
+	}
+
+	if m.DMARC == nil {
+		if !failed("DMARC") {
+			m.Issues = append(m.Issues, "No DMARC record")
+		}
+	} else {
+		switch m.DMARC.Policy {
+		case "reject":
+			score += 30
+		case "quarantine":
+			score += 20
+		default:
+			m.Issues = append(m.Issues, "DMARC policy is "+m.DMARC.Policy)
+			score += 5
+		}
+		if m.DMARC.Pct < 100 {
+			m.Issues = append(m.Issues, fmt.Sprintf("DMARC only applies to %d%% of mail", m.DMARC.Pct))
+			score -= 5
+		}
+	}
+
+	var dkimKeys int
+	for _, k := range m.DKIM {
+		if k.Revoked {
+			continue
+		}
+		dkimKeys++
+		if k.KeyType == "rsa" && k.Bits > 0 && k.Bits < 1024 {
+			m.Issues = append(m.Issues, fmt.Sprintf("DKIM key %s is only %d bits", k.Selector, k.Bits))
+		}
+	}
+	if dkimKeys > 0 {
+		score += 20
+	} else if !failed("DKIM") {
+		m.Issues = append(m.Issues, "No DKIM key found for the configured selectors")
+	}
+
+	switch {
+	case m.MTASTS == nil && failed("MTA-STS"):
+	case m.MTASTS == nil:
+		m.Issues = append(m.Issues, "No MTA-STS record")
+	case m.MTASTS.Error != "":
+		m.Issues = append(m.Issues, "MTA-STS: "+m.MTASTS.Error)
+	case m.MTASTS.Mode == "enforce":
+		score += 15
+	default:
+		m.Issues = append(m.Issues, "MTA-STS mode is "+m.MTASTS.Mode)
+		score += 7
+	}
+
+	if m.TLSRPT != nil {
+		score += 10
+	} else if !failed("TLS-RPT") {
+		m.Issues = append(m.Issues, "No TLS-RPT record")
+	}
+
+	m.Score = score
+	switch {
+	case score >= 90:
+		m.Grade = "A"
+	case score >= 75:
+		m.Grade = "B"
+	case score >= 55:
+		m.Grade = "C"
+	case score >= 35:
+		m.Grade = "D"
+	default:
+		m.Grade = "F"
+	}
+}
+
+// keepFailedMailChecks carries the previous result of the checks whose lookup failed over to the new check
+// Storing nothing instead would make a record removed after a failed lookup go unnoticed
+func keepFailedMailChecks(old, new *MailSecurity) {
+	if old == nil || new == nil || len(new.Failed) == 0 {
+		return
+	}
+	for _, check := range new.Failed {
+		switch check {
+		case "SPF":
+			new.SPF = old.SPF
+		case "DMARC":
+			new.DMARC = old.DMARC
+		case "DKIM":
+			new.DKIM = old.DKIM
+		case "MTA-STS":
+			new.MTASTS = old.MTASTS
+		case "TLS-RPT":
+			new.TLSRPT = old.TLSRPT
+		}
+	}
+	gradeMailSecurity(new)
+}
+
+// mailSecurityWeakened lists the records that disappeared or got weaker between two checks
+func mailSecurityWeakened(old, new *MailSecurity) []string {
+	if old == nil || new == nil {
+		return nil
+	}
+
+	// A check that failed this time isn't compared, the issue list shows it
+	var changes []string
+	switch {
+	case slices.Contains(new.Failed, "SPF"):
+	case old.SPF != nil && new.SPF == nil:
+		changes = append(changes, "SPF record removed")
+	case old.SPF != nil && spfAllStrength[new.SPF.All] < spfAllStrength[old.SPF.All]:
+		changes = append(changes, fmt.Sprintf("SPF weakened from %sall to %sall", old.SPF.All, new.SPF.All))
+	case old.SPF != nil && len(old.SPF.Errors) == 0 && len(new.SPF.Errors) > 0:
+		changes = append(changes, "SPF record broke: "+strings.Join(new.SPF.Errors, ", "))
+	}
+
+	switch {
+	case slices.Contains(new.Failed, "DMARC"):
+	case old.DMARC != nil && new.DMARC == nil:
+		changes = append(changes, "DMARC record removed")
+	case old.DMARC != nil && dmarcStrength[new.DMARC.Policy] < dmarcStrength[old.DMARC.Policy]:
+		changes = append(changes, fmt.Sprintf("DMARC weakened from p=%s to p=%s", old.DMARC.Policy, new.DMARC.Policy))
+	case old.DMARC != nil && new.DMARC.Pct < old.DMARC.Pct:
+		changes = append(changes, fmt.Sprintf("DMARC pct lowered from %d to %d", old.DMARC.Pct, new.DMARC.Pct))
+	}
+
+	for _, k := range old.DKIM {
+		if slices.Contains(new.Failed, "DKIM") {
+			break
+		}
+		i := slices.IndexFunc(new.DKIM, func(n DKIMResult) bool { return n.Selector == k.Selector })
+		if !k.Revoked && (i < 0 || new.DKIM[i].Revoked) {
+			changes = append(changes, fmt.Sprintf("DKIM key %s removed", k.Selector))
+		}
+	}
+
+	switch {
+	case slices.Contains(new.Failed, "MTA-STS"):
+	case old.MTASTS != nil && new.MTASTS == nil:
+		changes = append(changes, "MTA-STS record removed")
+	// A policy that couldn't be fetched this time isn't compared, the issue list shows it
+	case old.MTASTS != nil && old.MTASTS.Error == "" && new.MTASTS.Error == "" && mtaSTSStrength[new.MTASTS.Mode] < mtaSTSStrength[old.MTASTS.Mode]:
+		changes = append(changes, fmt.Sprintf("MTA-STS mode weakened from %s to %s", old.MTASTS.Mode, new.MTASTS.Mode))
+	}
+
+	if old.TLSRPT != nil && new.TLSRPT == nil && !slices.Contains(new.Failed, "TLS-RPT") {
+		changes = append(changes, "TLS-RPT record removed")
+	}
+	return changes
+}
+
+// mailSecurityChanged reports whether two checks differ
+func mailSecurityChanged(old, new *MailSecurity) bool {
+	a, _ := json.Marshal(old)
+	b, _ := json.Marshal(new)
+	return string(a) != string(b)
+}
+
+type mailSecurityAlert struct {
+	Domain  string
+	Grade   string
+	Changes []string
+}
+
+// sendMailSecurityAlerts emails the domains whose email authentication weakened
+func sendMailSecurityAlerts(alerts []mailSecurityAlert) error {
+	var list string
+	for _, a := range alerts {
+		subtitle := fmt.Sprintf("Email security grade %s", a.Grade)
+		list += domainCard("#f85149", getConfig().BaseURL+"/dash/?q="+a.Domain, a.Domain, subtitle, strings.Join(a.Changes, "<br>"))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">Email authentication records were removed or weakened for <strong>%d domain(s)</strong>.</p>`, len(alerts))
+	return sendEmail("Email security weakened", emailHTML("Email security weakened", intro+list))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMailSecurityLookupFailsThenRecordRemoved(t *testing.T) {
+	checked := func(m *MailSecurity) *MailSecurity {
+		gradeMailSecurity(m)
+		return m
+	}
+	good := checked(&MailSecurity{
+		SPF:    &SPFResult{Record: "v=spf1 -all", All: "-"},
+		DMARC:  &DMARCResult{Record: "v=DMARC1; p=reject", Policy: "reject", Pct: 100},
+		TLSRPT: &TLSRPTResult{Record: "v=TLSRPTv1; rua=mailto:tls@example.com"},
+	})
+
+	// The SPF and DMARC lookups fail: nothing is reported and the last results are kept
+	failed := checked(&MailSecurity{TLSRPT: good.TLSRPT, Failed: []string{"SPF", "DMARC"}})
+	if weakened := mailSecurityWeakened(good, failed); len(weakened) > 0 {
+		t.Errorf("failed lookups reported as %q", weakened)
+	}
+	keepFailedMailChecks(good, failed)
+	if failed.SPF != good.SPF || failed.DMARC != good.DMARC {
+		t.Fatal("the results of the failed checks weren't kept")
+	}
+	if !slices.Contains(failed.Issues, "SPF lookup failed") || slices.Contains(failed.Issues, "No SPF record") {
+		t.Errorf("issues = %q", failed.Issues)
+	}
+	if failed.Grade != good.Grade {
+		t.Errorf("grade = %s, want the kept %s", failed.Grade, good.Grade)
+	}
+
+	// Then the records are really removed
+	removed := checked(&MailSecurity{TLSRPT: good.TLSRPT})
+	keepFailedMailChecks(failed, removed)
+	want := []string{"SPF record removed", "DMARC record removed"}
+	if weakened := mailSecurityWeakened(failed, removed); !slices.Equal(weakened, want) {
+		t.Errorf("weakened = %q, want %q", weakened, want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+)
+
+// EPP status codes (RFC 5731, RGP statuses from RFC 3915), RDAP spells them as words ("client transfer prohibited", RFC 8056)
+var eppStatuses = []string{
+	"ok", "inactive", "addPeriod", "autoRenewPeriod", "renewPeriod", "transferPeriod",
+	"pendingCreate", "pendingDelete", "pendingRenew", "pendingRestore", "pendingTransfer", "pendingUpdate", "redemptionPeriod",
+	"clientDeleteProhibited", "clientHold", "clientRenewProhibited", "clientTransferProhibited", "clientUpdateProhibited",
+	"serverDeleteProhibited", "serverHold", "serverRenewProhibited", "serverTransferProhibited", "serverUpdateProhibited",
+}
+
+// Locks that protect a domain from being hijacked or deleted, their removal is alerted
+var eppLocks = []string{
+	"clientTransferProhibited", "serverTransferProhibited",
+	"clientUpdateProhibited", "serverUpdateProhibited",
+	"clientDeleteProhibited", "serverDeleteProhibited",
+}
+
+// Statuses that take a domain offline or are the last step before it's released, entering them is alerted
+var eppDanger = []string{"clientHold", "serverHold", "redemptionPeriod", "pendingDelete"}
+
+// normalizeStatuses turns RDAP and whois statuses into sorted EPP status codes
+// Whois sometimes appends the ICANN URL, RDAP calls ok "active"; unknown statuses are kept as they are
+func normalizeStatuses(statuses []string) []string {
+	normalized := []string{}
+	for _, s := range statuses {
+		fields := strings.Fields(s)
+		if len(fields) == 0 {
+			continue
+		}
+		// Drop a trailing URL (e.g. "clientTransferProhibited https://icann.org/epp#clientTransferProhibited")
+		if strings.HasPrefix(fields[len(fields)-1], "http") {
+			fields = fields[:len(fields)-1]
+		}
+		compact := strings.ToLower(strings.Join(fields, ""))
+		if compact == "active" {
+			compact = "ok"
+		}
+
+		status := strings.Join(fields, " ")
+		if i := slices.IndexFunc(eppStatuses, func(e string) bool { return strings.ToLower(e) == compact }); i >= 0 {
+			status = eppStatuses[i]
+		}
+		if status != "" && !slices.Contains(normalized, status) {
+			normalized = append(normalized, status)
+		}
+	}
+	slices.Sort(normalized)
+	return normalized
+}
+
+// statusChanges lists the locks removed and the dangerous statuses entered between two refreshes
+// Nothing is reported without a previous status set to compare with, or without a new one (whois often has no status lines)
+func statusChanges(old, new []string) []string {
+	if old == nil || len(new) == 0 {
+		return nil
+	}
+	var changes []string
+	for _, s := range eppLocks {
+		if slices.Contains(old, s) && !slices.Contains(new, s) {
+			changes = append(changes, s+" lock removed")
+		}
+	}
+	for _, s := range eppDanger {
+		if !slices.Contains(old, s) && slices.Contains(new, s) {
+			changes = append(changes, "Entered "+s)
+		}
+	}
+	return changes
+}
+
+// statusBadges renders a domain's statuses as small badges for the email cards
+func statusBadges(statuses []string) string {
+	var badges string
+	for _, s := range statuses {
+		color := "#6e7681"
+		if slices.Contains(eppDanger, s) {
+			color = "#f85149"
+		} else if slices.Contains(eppLocks, s) {
+			color = "#2da44e"
+		}
+		badges += fmt.Sprintf(`<span style="display:inline-block;margin:4px 4px 0 0;padding:1px 6px;border:1px solid %s;border-radius:10px;font-size:11px;color:%s;">%s</span>`, color, color, html.EscapeString(s))
+	}
+	return badges
+}
+
+type statusAlert struct {
+	Domain   Domain
+	Statuses []string
+	Changes  []string
+}
+
+func sendStatusAlerts(alerts []statusAlert) error {
+	var list string
+	for _, a := range alerts {
+		subtitle := "Registrar: " + html.EscapeString(a.Domain.Registrar) + "<br>" + statusBadges(a.Statuses)
+		list += domainCard("#f85149", getConfig().BaseURL+"/dash/?q="+a.Domain.Domain, html.EscapeString(a.Domain.Domain), subtitle, htmlLines(a.Changes))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The registry status of <strong>%d domain(s)</strong> changed: a lock was removed or the domain was put on hold or is being deleted. If this wasn't expected, contact the registrar right away.</p>`, len(alerts))
+	return sendEmail("Domain status changes detected", emailHTML("Domain status changes detected", intro+list))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStatusChanges(t *testing.T) {
+	locked := []string{"clientTransferProhibited", "clientUpdateProhibited"}
+	tests := []struct {
+		name     string
+		old, new []string
+		want     []string
+	}{
+		{"first refresh", nil, locked, nil},
+		{"unchanged", locked, locked, nil},
+		{"lock removed", locked, []string{"clientUpdateProhibited"}, []string{"clientTransferProhibited lock removed"}},
+		{"entered hold", locked, append([]string{"serverHold"}, locked...), []string{"Entered serverHold"}},
+		{"no status found", locked, []string{}, nil},
+		{"no status found (nil)", locked, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := statusChanges(tt.old, tt.new); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSameSource(t *testing.T) {
+	rdap, whois := sourceRDAP, sourceWhois
+	tests := []struct {
+		stored *string
+		source string
+		want   bool
+	}{
+		{nil, sourceWhois, true},
+		{&rdap, sourceRDAP, true},
+		{&rdap, sourceWhois, false},
+		{&whois, sourceRDAP, false},
+	}
+	for _, tt := range tests {
+		if got := sameSource(Domain{DataSource: tt.stored}, DomainData{Source: tt.source}); got != tt.want {
+			t.Errorf("sameSource(%v, %s) = %v, want %v", deref(tt.stored), tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestStatusBadgesEscaped(t *testing.T) {
+	badges := statusBadges([]string{`<img src=x onerror="alert(1)">`})
+	if strings.Contains(badges, "<img") || !strings.Contains(badges, "&lt;img") {
+		t.Errorf("status not escaped: %s", badges)
+	}
+}
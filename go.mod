@@ -7,9 +7,12 @@ require (
 	github.com/jackc/pgx/v5 v5.8.0
 	github.com/likexian/whois v1.15.7
 	github.com/likexian/whois-parser v1.24.21
+	github.com/miekg/dns v1.1.73
 	github.com/openrdap/rdap v0.9.1
 	github.com/wneessen/go-mail v0.7.2
-	golang.org/x/crypto v0.49.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/text v0.40.0
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -21,7 +24,7 @@ require (
 	github.com/likexian/gokit v0.25.16 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
 	github.com/xhit/go-str2duration/v2 v2.1.0 // indirect
-	golang.org/x/net v0.52.0 // indirect
-	golang.org/x/sync v0.20.0 // indirect
-	golang.org/x/text v0.35.0 // indirect
+	golang.org/x/net v0.57.0 // indirect
+	golang.org/x/sync v0.22.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
 )
@@ -3,17 +3,20 @@ package main
 import (
 	"context"
 	"crypto/rand"
-	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/likexian/whois"
 	whoisparser "github.com/likexian/whois-parser"
 	"github.com/openrdap/rdap"
@@ -55,6 +58,15 @@ func emailHTML(title, content string) string {
 }
 
 // domainCard returns an HTML card for a single domain/cert row in an email
+// htmlLines escapes lines of RDAP/whois data for an email, one per line
+func htmlLines(lines []string) string {
+	escaped := make([]string, len(lines))
+	for i, l := range lines {
+		escaped[i] = html.EscapeString(l)
+	}
+	return strings.Join(escaped, "<br>")
+}
+
 func domainCard(borderColor, linkURL, name, subtitle, badge string) string {
 	return fmt.Sprintf(`
 <table width="100%%" cellpadding="0" cellspacing="0" style="margin-bottom:12px;border:1px solid #e1e4e8;border-radius:8px;overflow:hidden;">
@@ -99,48 +111,109 @@ func generateSessionToken() string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func checkSessionToken(r *http.Request) (int, error) {
+func checkSessionToken(r *http.Request) (AuthUser, error) {
 	cookie, err := r.Cookie("session")
 	if err != nil {
-		return -1, err
+		return AuthUser{}, err
 	}
 
+	var user AuthUser
 	var session Session
-	err = db.QueryRow(context.TODO(), "SELECT userId, expires FROM sessions WHERE token = $1", cookie.Value).Scan(&session.UserID, &session.Expiry)
+	var disabled bool
+	err = db.QueryRow(context.TODO(), "SELECT u.id, u.username, u.role, u.disabled, s.id, s.expires, s.lastSeen FROM sessions s JOIN users u ON u.id = s.userId WHERE s.token = $1", cookie.Value).
+		Scan(&user.ID, &user.Username, &user.Role, &disabled, &session.ID, &session.Expiry, &session.LastSeen)
 	if err != nil {
-		return -1, err
+		return AuthUser{}, err
 	}
 
 	// check if the session is expired
 	if time.Now().After(session.Expiry) {
-		return -1, errors.New("session expired")
+		return AuthUser{}, errors.New("session expired")
+	}
+	// disabled users keep no access, even with a live session
+	if disabled {
+		return AuthUser{}, errors.New("user disabled")
+	}
+
+	if user.Scoped() {
+		user.ClientIDs, err = getUserClients(user.ID)
+		if err != nil {
+			return AuthUser{}, err
+		}
 	}
-	return session.UserID, nil
+
+	user.SessionID = session.ID
+	user.sessionLastSeen = session.LastSeen
+	return user, nil
 }
 
-func ResolveDNS(domain string, class string) []string {
-	dnsRes, err := http.Get("https://dns.google/resolve?name=" + domain + "&type=" + class)
+// clientIP returns the IP address of the client making the request
+// X-Forwarded-For is only honored when the request comes from a trusted proxy (trustedProxies in the config)
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		log.Print(err)
-		return []string{}
+		host = r.RemoteAddr
 	}
-	defer dnsRes.Body.Close()
-	var DNSResponse GoogDNSResponse
-	if err := json.NewDecoder(dnsRes.Body).Decode(&DNSResponse); err != nil {
-		log.Print(err)
-		return []string{}
+
+	proxies := parseTrustedProxies(getConfig().TrustedProxies)
+	if !isTrustedProxy(host, proxies) {
+		return host
 	}
-	if DNSResponse.Status == 0 {
-		Records := make([]string, 0, len(DNSResponse.Answer))
-		for _, ans := range DNSResponse.Answer {
-			Records = append(Records, strings.ToLower(ans.Data))
+
+	// Walk the forwarded chain from the closest hop, the first address that isn't a trusted proxy is the client
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !isTrustedProxy(hop, proxies) {
+			break
 		}
-		return Records
 	}
-	return []string{}
+	return host
+}
+
+// getUserClients returns the IDs of the clients a user is assigned to
+func getUserClients(userID int) ([]int, error) {
+	rows, err := db.Query(context.TODO(), "SELECT clientId FROM user_clients WHERE userId = $1 ORDER BY clientId", userID)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[int])
 }
 
-func fetchDomainData(domain string) (exp time.Time, ns []string, reg string, rawData string, dns DNS, err error) {
+// isUniqueViolation reports whether err was caused by a UNIQUE constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// isForeignKeyViolation reports whether err was caused by a reference to a missing row
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+// Where fetchDomainData got a domain's data
+const (
+	sourceRDAP  = "rdap"
+	sourceWhois = "whois"
+)
+
+// sameSource tells whether a refresh's data comes from the same source as the stored data
+// RDAP and whois don't list the same statuses and contacts, so data from different sources isn't compared
+func sameSource(d Domain, data DomainData) bool {
+	return d.DataSource == nil || *d.DataSource == data.Source
+}
+
+// fetchDomainData looks a domain up over RDAP (falling back to whois) and resolves its DNS records
+func fetchDomainData(domain string) (DomainData, error) {
+	var data DomainData
 	client := &rdap.Client{}
 
 	query, err := client.QueryDomain(domain)
@@ -150,77 +223,86 @@ func fetchDomainData(domain string) (exp time.Time, ns []string, reg string, raw
 		result, err := whois.Whois(domain)
 		if err != nil {
 			log.Println(err)
-			return time.Time{}, []string{}, "", "", DNS{}, err
+			return DomainData{}, err
 		}
 		res, err := whoisparser.Parse(result)
 		if err != nil {
 			log.Println(err)
-			return time.Time{}, []string{}, "", "", DNS{}, err
+			return DomainData{}, err
 		}
 
-		exp = *res.Domain.ExpirationDateInTime
+		data.Expiration = *res.Domain.ExpirationDateInTime
 		// Get nameservers
-		nameservers := make([]string, 0, len(res.Domain.NameServers))
+		data.Nameservers = make([]string, 0, len(res.Domain.NameServers))
 		for _, ns := range res.Domain.NameServers {
-			nameservers = append(nameservers, strings.ToLower(ns))
+			data.Nameservers = append(data.Nameservers, strings.ToLower(ns))
 		}
 
-		ns = nameservers
-		reg = res.Registrar.Name
+		data.Source = sourceWhois
+		data.Registrar = res.Registrar.Name
+		data.Status = normalizeStatuses(res.Domain.Status)
+		data.Contacts = whoisContacts(res)
 		mRawData, e := json.Marshal(res)
 		if e != nil {
 			log.Print(e)
-			rawData = "<error>"
+			data.RawData = "<error>"
 		} else {
-			rawData = string(mRawData)
+			data.RawData = string(mRawData)
 		}
 	} else {
+		data.Source = sourceRDAP
 		// Extract expiration date from RDAP events
 		for i := range query.Events {
 			if query.Events[i].Action == "expiration" {
-				exp, err = time.Parse(time.RFC3339, query.Events[i].Date)
+				data.Expiration, err = time.Parse(time.RFC3339, query.Events[i].Date)
 				if err != nil {
 					log.Print(err)
-					return time.Time{}, []string{}, "", "", DNS{}, err
+					return DomainData{}, err
 				}
 				break
 			}
 		}
 
 		// Get nameservers
-		nameservers := make([]string, 0, len(query.Nameservers))
+		data.Nameservers = make([]string, 0, len(query.Nameservers))
 		for _, ns := range query.Nameservers {
-			nameservers = append(nameservers, strings.ToLower(ns.LDHName))
+			data.Nameservers = append(data.Nameservers, strings.ToLower(ns.LDHName))
 		}
-		ns = nameservers
 
 		// Get registrar
 		for _, entity := range query.Entities {
 			if len(entity.Roles) > 0 && entity.Roles[0] == "registrar" {
-				reg = entity.VCard.Name()
+				data.Registrar = entity.VCard.Name()
 			}
 		}
 
+		data.Status = normalizeStatuses(query.Status)
+		data.Contacts = rdapContacts(query)
+
+		// Get the DS records published in the parent zone
+		if query.SecureDNS != nil {
+			data.DS = rdapDSRecords(query.SecureDNS)
+		}
+
 		// Get the raw RDAP JSON response
 		jsonBytes, err := json.Marshal(query)
 		if err != nil {
 			// soft fail
 			log.Printf("Error marshaling RDAP response to JSON: %v", err)
-			rawData = "<error>"
+			data.RawData = "<error>"
 		} else {
-			rawData = string(jsonBytes)
+			data.RawData = string(jsonBytes)
 		}
 	}
 
 	// Get DNS
-	dns = DNS{
-		A:    ResolveDNS(domain, "A"),
-		AAAA: ResolveDNS(domain, "AAAA"),
-		MX:   ResolveDNS(domain, "MX"),
-		NS:   ResolveDNS(domain, "NS"),
+	data.DNS, err = resolveDomainDNS(domain)
+	if err != nil {
+		log.Println(err)
+		return DomainData{}, err
 	}
 
-	return exp, ns, reg, rawData, dns, nil
+	return data, nil
 }
 
 func sendEmail(subj string, body string) error {
@@ -263,20 +345,3 @@ func TrimDot(ns []string) []string {
 	}
 	return normalized
 }
-
-func getTLSCert(domain string) (CommonName string, validTo time.Time, issuer string, rawData []byte, err error) {
-	// Connect to the server of the domain on port 443 and get the TLS certificate
-	conn, err := tls.Dial("tcp", domain+":443", nil)
-	if err != nil {
-		return "", time.Time{}, "", nil, err
-	}
-	defer conn.Close()
-
-	// Get the certificate
-	cert := conn.ConnectionState().PeerCertificates[0]
-	certJSON, err := json.Marshal(cert)
-	if err != nil {
-		return "", time.Time{}, "", nil, err
-	}
-	return cert.Subject.CommonName, cert.NotAfter, cert.Issuer.CommonName, certJSON, nil
-}
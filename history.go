@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Values of domain_history.changes
+const (
+	historyTracked     = "tracked" // the domain was added
+	historyExpiration  = "expiration"
+	historyRegistrar   = "registrar"
+	historyNameservers = "nameservers"
+	historyStatus      = "status" // EPP status codes, only compared once a refresh stored them
+)
+
+// recordDomainHistory adds a timeline entry when a refresh changed the tracked fields (see historyChanges)
+// The raw RDAP/whois data is only kept with the entries of a new domain or registrar
+// before is nil for newly added domains
+func recordDomainHistory(before *Domain, after Domain) {
+	changes := historyChanges(before, after)
+	if len(changes) == 0 {
+		return
+	}
+
+	var oldExp, oldReg, raw any
+	if before != nil {
+		oldExp, oldReg = before.Expiration, before.Registrar
+	}
+	if before == nil || slices.Contains(changes, historyRegistrar) {
+		raw = after.RawWhoisData
+	}
+	_, err := db.Exec(context.TODO(), "INSERT INTO domain_history (domainId, changes, oldExpiration, expiration, oldRegistrar, registrar, rawWhoisData) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		after.ID, changes, oldExp, after.Expiration, oldReg, after.Registrar, raw)
+	if err != nil {
+		log.Printf("Failed to record history for %s: %v\n", after.Domain, err)
+	}
+}
+
+// historyChanges lists which of the expiration, registrar, nameservers and statuses differ
+// The raw RDAP/whois data isn't compared, it changes on every query (e.g. RDAP's "last update of RDAP database" event)
+func historyChanges(before *Domain, after Domain) []string {
+	if before == nil {
+		return []string{historyTracked}
+	}
+	var changes []string
+	if !before.Expiration.Equal(after.Expiration) {
+		changes = append(changes, historyExpiration)
+	}
+	if before.Registrar != after.Registrar {
+		changes = append(changes, historyRegistrar)
+	}
+	// Registries don't always list the nameservers in the same order
+	if !slices.Equal(slices.Sorted(slices.Values(before.Nameservers)), slices.Sorted(slices.Values(after.Nameservers))) {
+		changes = append(changes, historyNameservers)
+	}
+	if before.Status != nil && !slices.Equal(before.Status, after.Status) {
+		changes = append(changes, historyStatus)
+	}
+	return changes
+}
+
+// Handle the /api/domainHistory/:id route, returns a domain's timeline (oldest first)
+// The raw RDAP/whois data of each entry is only included with ?raw=true
+func domainHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract the ID from the URL path
+	// Expected format: /api/domainHistory/:id
+	id := strings.Split(r.URL.Path, "/")[3]
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	// Client accounts only see their own domains
+	exists, err := domainInScope(currentUser(r), id)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if !exists {
+		http.Error(w, "Domain not found", http.StatusNotFound)
+		return
+	}
+
+	rows, err := db.Query(context.TODO(), `
+		SELECT id, domainId, recordedAt, changes, oldExpiration, expiration, oldRegistrar, registrar,
+			CASE WHEN $2 THEN rawWhoisData::text END AS rawwhoisdata
+		FROM domain_history WHERE domainId = $1 ORDER BY recordedAt, id`,
+		id, r.URL.Query().Get("raw") == "true")
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	history, err := pgx.CollectRows(rows, pgx.RowToStructByName[DomainHistory])
+	if err != nil {
+		http.Error(w, "Error reading domain history", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
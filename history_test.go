@@ -0,0 +1,41 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHistoryChanges(t *testing.T) {
+	exp := time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC)
+	before := Domain{
+		Expiration: exp, Registrar: "Registrar", Nameservers: []string{"ns1.example.net", "ns2.example.net"},
+		Status: []string{"clientTransferProhibited"}, RawWhoisData: `{"events":[{"eventAction":"last update of RDAP database","eventDate":"2026-10-16T08:00:00Z"}]}`,
+	}
+	refreshed := func(change func(d *Domain)) Domain {
+		d := before
+		d.RawWhoisData = `{"events":[{"eventAction":"last update of RDAP database","eventDate":"2026-10-17T08:00:00Z"}]}`
+		change(&d)
+		return d
+	}
+
+	tests := []struct {
+		name  string
+		after Domain
+		want  []string
+	}{
+		{"only the raw data", refreshed(func(d *Domain) {}), nil},
+		{"nameservers reordered", refreshed(func(d *Domain) { d.Nameservers = []string{"ns2.example.net", "ns1.example.net"} }), nil},
+		{"renewed", refreshed(func(d *Domain) { d.Expiration = exp.AddDate(1, 0, 0) }), []string{historyExpiration}},
+		{"transferred", refreshed(func(d *Domain) { d.Registrar = "Other"; d.Nameservers = []string{"ns1.other.net"} }), []string{historyRegistrar, historyNameservers}},
+		{"lock removed", refreshed(func(d *Domain) { d.Status = []string{} }), []string{historyStatus}},
+	}
+	for _, tt := range tests {
+		if got := historyChanges(&before, tt.after); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := historyChanges(nil, before); !slices.Equal(got, []string{historyTracked}) {
+		t.Errorf("new domain: got %q", got)
+	}
+}
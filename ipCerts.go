@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// hostAddresses resolves all the A and AAAA addresses of a host
+func hostAddresses(host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	var addrs []string
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := lookup(host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range rrs {
+			switch rr := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, rr.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, rr.AAAA.String())
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s has no A or AAAA records", host)
+	}
+	slices.Sort(addrs)
+	return slices.Compact(addrs), nil
+}
+
+// getIPCerts fetches the certificate from every address of an endpoint's host, with the host name as SNI
+// A backend behind round-robin DNS that still serves an old certificate only shows up this way
+func getIPCerts(e TLSEndpoint) ([]IPCert, error) {
+	addrs, err := hostAddresses(e.Host)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := []IPCert{}
+	for _, addr := range addrs {
+		ep := e
+		ep.Addr = addr
+		data, err := getTLSCert(ep)
+		if err != nil {
+			endpoints = append(endpoints, IPCert{IP: addr, Error: err.Error()})
+			continue
+		}
+		endpoints = append(endpoints, IPCert{IP: addr, Serial: data.Serial, Fingerprint: data.Fingerprint, Expiration: data.Expiration, Status: data.Validation.Status})
+	}
+	return endpoints, nil
+}
+
+// ipCertProblems reports the addresses serving different certificates (serial or expiry), unreachable addresses aren't compared
+func ipCertProblems(endpoints []IPCert) []string {
+	groups := map[string][]string{}
+	var keys []string
+	for _, ep := range endpoints {
+		if ep.Error != "" {
+			continue
+		}
+		key := fmt.Sprintf("serial %s, expires %s", ep.Serial, ep.Expiration.Format("01/02/2006"))
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], ep.IP)
+	}
+	if len(keys) < 2 {
+		return nil
+	}
+	problems := []string{}
+	for _, key := range keys {
+		problems = append(problems, fmt.Sprintf("%s: %s", strings.Join(groups[key], ", "), key))
+	}
+	return problems
+}
+
+func saveIPCerts(id int, endpoints []IPCert) error {
+	_, err := db.Exec(context.TODO(), "UPDATE crts SET endpoints = $1 WHERE id = $2", endpoints, id)
+	return err
+}
+
+type ipCertAlert struct {
+	Cert     TLSDomain
+	Problems []string
+}
+
+func sendIPCertAlerts(alerts []ipCertAlert) {
+	var list string
+	for _, a := range alerts {
+		subtitle := fmt.Sprintf("%s &middot; Authority: %s", a.Cert.Domain, a.Cert.Authority)
+		list += domainCard("#e3b341", getConfig().BaseURL+"/dash/tls/?q="+certName(a.Cert), certName(a.Cert), subtitle, strings.Join(a.Problems, "<br>"))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The addresses of <strong>%d host(s)</strong> don't all serve the same certificate. A backend was probably missed when the certificate was renewed.</p>`, len(alerts))
+	if err := sendEmail("Certificate mismatch between endpoints", emailHTML("Certificate mismatch between endpoints", intro+list)); err != nil {
+		log.Printf("Failed to send endpoint certificate alert email: %v\n", err)
+	}
+}
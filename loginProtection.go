@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// Failed logins allowed (per IP or username) before every further attempt is delayed
+	loginFreeAttempts = 3
+	// The delay starts at loginBackoffBase and doubles with every failure, up to loginBackoffMax
+	loginBackoffBase = time.Second
+	loginBackoffMax  = 15 * time.Minute
+	// Failures older than this are forgotten
+	loginFailureWindow = time.Hour
+	// Old entries are pruned early when the limiter grows past this many (e.g. during a distributed attack)
+	loginLimiterMaxEntries = 10000
+
+	// Consecutive failed logins before an account is locked, and for how long
+	accountLockoutThreshold = 10
+	accountLockoutDuration  = 15 * time.Minute
+)
+
+// Reasons recorded in login_attempts
+const (
+	loginOK            = "ok"
+	loginTOTPRequired  = "totp_required"
+	loginUnknownUser   = "unknown_user"
+	loginBadPassword   = "bad_password"
+	loginBadCode       = "bad_code"
+	loginDisabled      = "disabled"
+	loginLocked        = "locked"
+	loginRateLimited   = "rate_limited"
+	loginSSOOK         = "sso"
+	loginSSORejected   = "sso_rejected"
+	loginChallengeGone = "challenge_expired"
+)
+
+type loginBackoff struct {
+	failures int
+	last     time.Time
+	next     time.Time // no attempts allowed before this
+}
+
+// loginLimiter tracks failed logins per IP and per username in memory
+var loginLimiter = struct {
+	mu      sync.Mutex
+	entries map[string]*loginBackoff
+}{entries: map[string]*loginBackoff{}}
+
+func limiterKeys(ip, username string) []string {
+	keys := []string{"ip:" + ip}
+	if username != "" {
+		keys = append(keys, "user:"+strings.ToLower(username))
+	}
+	return keys
+}
+
+// loginRetryAfter returns how long the IP/username has to wait before trying again (0 if it may try now)
+func loginRetryAfter(ip, username string) time.Duration {
+	loginLimiter.mu.Lock()
+	defer loginLimiter.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range limiterKeys(ip, username) {
+		if e, ok := loginLimiter.entries[key]; ok {
+			wait = max(wait, time.Until(e.next))
+		}
+	}
+	return wait
+}
+
+// loginFailed records a failed login and backs off exponentially once the free attempts are used up
+func loginFailed(ip, username string) {
+	loginLimiter.mu.Lock()
+	defer loginLimiter.mu.Unlock()
+
+	if len(loginLimiter.entries) > loginLimiterMaxEntries {
+		pruneLoginBackoffs()
+	}
+
+	now := time.Now()
+	for _, key := range limiterKeys(ip, username) {
+		e, ok := loginLimiter.entries[key]
+		if !ok || now.Sub(e.last) > loginFailureWindow {
+			e = &loginBackoff{}
+			loginLimiter.entries[key] = e
+		}
+		e.failures++
+		e.last = now
+		if e.failures > loginFreeAttempts {
+			exp := float64(e.failures - loginFreeAttempts - 1)
+			delay := time.Duration(float64(loginBackoffBase) * math.Pow(2, min(exp, 20)))
+			e.next = now.Add(min(delay, loginBackoffMax))
+		}
+	}
+}
+
+// loginSucceeded clears the failures of the IP and username
+func loginSucceeded(ip, username string) {
+	loginLimiter.mu.Lock()
+	defer loginLimiter.mu.Unlock()
+
+	for _, key := range limiterKeys(ip, username) {
+		delete(loginLimiter.entries, key)
+	}
+}
+
+// pruneLoginLimiter forgets old failures
+func pruneLoginLimiter() {
+	loginLimiter.mu.Lock()
+	defer loginLimiter.mu.Unlock()
+	pruneLoginBackoffs()
+}
+
+// pruneLoginBackoffs does the work of pruneLoginLimiter, the caller must hold the lock
+func pruneLoginBackoffs() {
+	for key, e := range loginLimiter.entries {
+		if time.Since(e.last) > loginFailureWindow && time.Now().After(e.next) {
+			delete(loginLimiter.entries, key)
+		}
+	}
+}
+
+// rejectRateLimited answers a login attempt that came too soon
+func rejectRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+}
+
+// rejectLocked answers a login attempt for a locked account
+func rejectLocked(w http.ResponseWriter, until time.Time) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	http.Error(w, "Account temporarily locked after too many failed logins, try again later", http.StatusLocked)
+}
+
+// recordLoginAttempt persists a login attempt, userID may be 0 for unknown users
+func recordLoginAttempt(r *http.Request, username string, userID int, success bool, reason string) {
+	var uid *int
+	if userID != 0 {
+		uid = &userID
+	}
+	_, err := db.Exec(context.TODO(), "INSERT INTO login_attempts (username, userId, ip, userAgent, success, reason) VALUES ($1, $2, $3, $4, $5, $6)",
+		username, uid, clientIP(r), r.UserAgent(), success, reason)
+	if err != nil {
+		log.Printf("Failed to record login attempt: %v\n", err)
+	}
+}
+
+// accountFailedLogin counts a failed login against the account and locks it once it reaches the threshold
+func accountFailedLogin(userID int) {
+	_, err := db.Exec(context.TODO(), `
+		UPDATE users SET failedLogins = failedLogins + 1,
+			lockedUntil = CASE WHEN failedLogins + 1 >= $1 THEN $2 ELSE lockedUntil END
+		WHERE id = $3`,
+		accountLockoutThreshold, time.Now().Add(accountLockoutDuration), userID)
+	if err != nil {
+		log.Printf("Failed to count failed login: %v\n", err)
+	}
+}
+
+// accountLoginOK resets the failed login counter of an account
+func accountLoginOK(userID int) {
+	if _, err := db.Exec(context.TODO(), "UPDATE users SET failedLogins = 0, lockedUntil = NULL WHERE id = $1", userID); err != nil {
+		log.Printf("Failed to reset failed logins: %v\n", err)
+	}
+}
+
+// parseTrustedProxies parses the trustedProxies config entries (IPs or CIDRs)
+func parseTrustedProxies(entries []string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, e := range entries {
+		if !strings.Contains(e, "/") {
+			if ip := net.ParseIP(e); ip != nil && ip.To4() != nil {
+				e += "/32"
+			} else {
+				e += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q: %v\n", e, err)
+			continue
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isTrustedProxy(ip string, proxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle the /api/loginAttempts route, lists recent login attempts
+// Filters: ?username=, ?ip=, ?success=true|false, ?limit= (default 100)
+func loginAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var username, ip *string
+	var success *bool
+	if v := q.Get("username"); v != "" {
+		username = &v
+	}
+	if v := q.Get("ip"); v != "" {
+		ip = &v
+	}
+	if v := q.Get("success"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "Invalid success filter", http.StatusBadRequest)
+			return
+		}
+		success = &b
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := db.Query(context.TODO(), `
+		SELECT id, at, username, userId, ip, userAgent, success, reason FROM login_attempts
+		WHERE ($1::text IS NULL OR username = $1) AND ($2::text IS NULL OR ip = $2) AND ($3::boolean IS NULL OR success = $3)
+		ORDER BY at DESC LIMIT $4`,
+		username, ip, success, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	attempts, err := pgx.CollectRows(rows, pgx.RowToStructByName[LoginAttempt])
+	if err != nil {
+		http.Error(w, "Error reading login attempts", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
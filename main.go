@@ -35,11 +35,22 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Slow down repeated failures from the same IP or against the same username
+	ip := clientIP(r)
+	if wait := loginRetryAfter(ip, loginReq.Username); wait > 0 {
+		recordLoginAttempt(r, loginReq.Username, 0, false, loginRateLimited)
+		rejectRateLimited(w, wait)
+		return
+	}
+
 	// Find the user with the username in the DB
 	var user DbUser
-	err := db.QueryRow(context.TODO(), "SELECT id, password FROM users WHERE username = $1", loginReq.Username).Scan(&user.ID, &user.Password)
+	err := db.QueryRow(context.TODO(), "SELECT id, password, disabled, totpEnabled, lockedUntil FROM users WHERE username = $1", loginReq.Username).
+		Scan(&user.ID, &user.Password, &user.Disabled, &user.TOTPEnabled, &user.LockedUntil)
 	if err != nil {
 		if err == pgx.ErrNoRows {
+			loginFailed(ip, loginReq.Username)
+			recordLoginAttempt(r, loginReq.Username, 0, false, loginUnknownUser)
 			http.Error(w, "Invalid username or password", http.StatusForbidden)
 			return
 		}
@@ -48,24 +59,50 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		recordLoginAttempt(r, loginReq.Username, user.ID, false, loginLocked)
+		rejectLocked(w, *user.LockedUntil)
+		return
+	}
+
 	// Compare the provided password with the stored hash
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginReq.Password)); err != nil {
+		loginFailed(ip, loginReq.Username)
+		accountFailedLogin(user.ID)
+		recordLoginAttempt(r, loginReq.Username, user.ID, false, loginBadPassword)
 		http.Error(w, "Invalid username or password", http.StatusForbidden)
 		return
 	}
 
-	// generate a new session token
-	token := generateSessionToken()
-	// store token in the database
-	_, err = db.Exec(context.TODO(), "INSERT INTO sessions (token, userId, expires) VALUES ($1, $2, $3)", token, user.ID, time.Now().Add(48*time.Hour))
-	if err != nil {
+	if user.Disabled {
+		recordLoginAttempt(r, loginReq.Username, user.ID, false, loginDisabled)
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
+	// Users with two-factor authentication need to pass the second step (/api/loginTOTP) first
+	// (failures are only cleared once it succeeds)
+	if user.TOTPEnabled {
+		challenge, err := createLoginChallenge(user.ID)
+		if err != nil {
+			http.Error(w, "Failed to create login challenge", http.StatusInternalServerError)
+			log.Print(err)
+			return
+		}
+		recordLoginAttempt(r, loginReq.Username, user.ID, true, loginTOTPRequired)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LoginResponse{TOTPRequired: true, Challenge: challenge})
+		return
+	}
+
+	loginSucceeded(ip, loginReq.Username)
+	accountLoginOK(user.ID)
+	if err := createSession(w, r, user.ID); err != nil {
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		log.Print(err)
 		return
 	}
-
-	// Set cookie on client
-	w.Header().Set("Set-Cookie", "session="+token+"; Max-Age=86400; Path=/; httpOnly; SameSite=Strict; Secure")
+	recordLoginAttempt(r, loginReq.Username, user.ID, true, loginOK)
 }
 
 // Handle the /api/get route
@@ -75,18 +112,8 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	// Get all rows from the domains SQL table
-	rows, err := db.Query(context.TODO(), "SELECT * FROM domains")
+	// Get all rows from the domains SQL table (only the user's clients for client accounts)
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains WHERE $1::int[] IS NULL OR clientId = ANY($1)", currentUser(r).clientScope())
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		log.Print(err)
@@ -113,20 +140,53 @@ func getHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(domains)
 }
 
-// Handle the /api/edit route
-func editHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
+// Handle the /api/reminders route
+// Lists the domains and certificates within their reminder periods (the same ones the reminder emails contain)
+func remindersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
+	scope := currentUser(r).clientScope()
+	var reminders Reminders
+
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains WHERE expiration < $1 AND ($2::int[] IS NULL OR clientId = ANY($2)) ORDER BY expiration",
+		time.Now().AddDate(0, 0, getConfig().DaysDomainExp), scope)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	reminders.Domains, err = pgx.CollectRows(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		http.Error(w, "Error reading domains", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	rows, err = db.Query(context.TODO(), "SELECT * FROM crts WHERE expiration < $1 AND ($2::int[] IS NULL OR clientId = ANY($2)) ORDER BY expiration",
+		time.Now().AddDate(0, 0, getConfig().DaysCertExp), scope)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	reminders.Certs, err = pgx.CollectRows(rows, pgx.RowToStructByName[TLSDomain])
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		http.Error(w, "Error reading certificates", http.StatusInternalServerError)
+		log.Print(err)
 		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reminders)
+}
+
+// Handle the /api/edit route
+func editHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -144,17 +204,32 @@ func editHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := db.Exec(context.TODO(), "UPDATE domains SET clientid = $1, notes = $2 WHERE id = $3", req.ClientID, req.Notes, req.ID)
+	// Keep the old values for the audit log
+	before, err := getDomain(req.ID)
+	if err != nil {
+		// Make sure the domain was found
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Domain not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	rows, err := db.Query(context.TODO(), "UPDATE domains SET clientid = $1, notes = $2 WHERE id = $3 RETURNING *", req.ClientID, req.Notes, req.ID)
 	if err != nil {
 		http.Error(w, "Failed to update domain", http.StatusInternalServerError)
 		log.Print(err)
 		return
 	}
-	// Make sure the domain was found (and updated)
-	if c.RowsAffected() == 0 {
-		http.Error(w, "Domain not found", http.StatusNotFound)
+	after, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		http.Error(w, "Failed to update domain", http.StatusInternalServerError)
+		log.Print(err)
 		return
 	}
+	recordAudit(userActor(r), auditUpdate, auditDomain, req.ID, auditDomainValue(before), auditDomainValue(after))
 }
 
 // Handle (/api/add) adding a domain to the DB and fetching additional (required) metadata (using RDAP [preferred] or whois)
@@ -164,16 +239,6 @@ func addHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	// Decode the JSON request body
 	var domain DomainReqBody
 	if err := json.NewDecoder(r.Body).Decode(&domain); err != nil {
@@ -188,7 +253,7 @@ func addHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch domain data (helpers.go)
-	exp, ns, reg, rawData, dns, err := fetchDomainData(domain.Domain)
+	data, err := fetchDomainData(domain.Domain)
 	if err != nil {
 		http.Error(w, "Failed to fetch domain data", http.StatusInternalServerError)
 		log.Println(err)
@@ -196,21 +261,42 @@ func addHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert the new domain into the DB
-	_, err = db.Exec(context.TODO(), "INSERT INTO domains (domain, expiration, nameservers, registrar, dns, clientid, rawwhoisdata, notes) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+	rows, err := db.Query(context.TODO(), `INSERT INTO domains (domain, expiration, nameservers, registrar, dns, clientid, rawwhoisdata, notes, ds, status, registrantOrg, registrantCountry, registrantPrivacy, techContact, abuseContact, dataSource, lastCheckedAt, nextCheckAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, now(), $17) RETURNING *`,
 		domain.Domain,
-		exp,
-		ns,
-		reg,
-		dns,
+		data.Expiration,
+		data.Nameservers,
+		data.Registrar,
+		data.DNS,
 		domain.ClientID,
-		rawData,
+		data.RawData,
 		domain.Notes,
+		data.DS,
+		data.Status,
+		nilIfEmpty(data.Contacts.Registrant.Organization),
+		nilIfEmpty(data.Contacts.Registrant.Country),
+		data.Contacts.Registrant.Privacy,
+		data.Contacts.Tech,
+		data.Contacts.Abuse,
+		data.Source,
+		nextDomainCheck(data.Expiration),
 	)
 	if err != nil {
 		log.Print(err)
 		http.Error(w, "Failed to add domain", http.StatusInternalServerError)
 		return
 	}
+	added, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "Failed to add domain", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(userActor(r), auditCreate, auditDomain, added.ID, nil, auditDomainValue(added))
+	recordDomainHistory(nil, added)
+	if err := saveDNSSnapshot(added.ID, added.DNS, false); err != nil {
+		log.Printf("Failed to save DNS snapshot for domain %s: %v\n", added.Domain, err)
+	}
 }
 
 func clientListHandler(w http.ResponseWriter, r *http.Request) {
@@ -219,18 +305,8 @@ func clientListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	// Get all rows from the clients SQL table
-	rows, err := db.Query(context.TODO(), "SELECT * FROM clients")
+	// Get all rows from the clients SQL table (only the user's clients for client accounts)
+	rows, err := db.Query(context.TODO(), "SELECT * FROM clients WHERE $1::int[] IS NULL OR id = ANY($1)", currentUser(r).clientScope())
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		log.Print(err)
@@ -262,16 +338,6 @@ func clientAddHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	// Get the request body and parse it
 	var client Client
 	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
@@ -286,12 +352,13 @@ func clientAddHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Exec(context.TODO(), "INSERT INTO clients (name) VALUES ($1)", client.Name)
+	err := db.QueryRow(context.TODO(), "INSERT INTO clients (name) VALUES ($1) RETURNING id", client.Name).Scan(&client.ID)
 	if err != nil {
 		http.Error(w, "Failed to add client", http.StatusInternalServerError)
 		log.Print(err)
 		return
 	}
+	recordAudit(userActor(r), auditCreate, auditClient, client.ID, nil, client)
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(client)
@@ -303,16 +370,6 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	// Extract the ID from the URL path
 	// Expected format: /api/delete/:id
 	id := strings.Split(r.URL.Path, "/")[3]
@@ -321,17 +378,24 @@ func deleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := db.Exec(context.TODO(), "DELETE FROM domains WHERE id = $1", id)
+	rows, err := db.Query(context.TODO(), "DELETE FROM domains WHERE id = $1 RETURNING *", id)
 	if err != nil {
 		http.Error(w, "Failed to delete domain", http.StatusInternalServerError)
 		log.Print(err)
 		return
 	}
-	// Make sure the domain was found (and deleted)
-	if c.RowsAffected() == 0 {
-		http.Error(w, "Domain not found", http.StatusNotFound)
+	deleted, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		// Make sure the domain was found (and deleted)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Domain not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete domain", http.StatusInternalServerError)
+		log.Print(err)
 		return
 	}
+	recordAudit(userActor(r), auditDelete, auditDomain, deleted.ID, auditDomainValue(deleted), nil)
 }
 
 func deleteClientHandler(w http.ResponseWriter, r *http.Request) {
@@ -340,16 +404,6 @@ func deleteClientHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	// Extract the ID from the URL path
 	// Expected format: /api/deleteClient/:id
 	id := strings.Split(r.URL.Path, "/")[3]
@@ -358,17 +412,19 @@ func deleteClientHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := db.Exec(context.TODO(), "DELETE FROM clients WHERE id = $1", id)
+	var deleted Client
+	err := db.QueryRow(context.TODO(), "DELETE FROM clients WHERE id = $1 RETURNING id, name", id).Scan(&deleted.ID, &deleted.Name)
 	if err != nil {
+		// Make sure the client was found (and deleted)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Client not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to delete client", http.StatusInternalServerError)
 		log.Print(err)
 		return
 	}
-	// Make sure the client was found (and deleted)
-	if c.RowsAffected() == 0 {
-		http.Error(w, "Client not found", http.StatusNotFound)
-		return
-	}
+	recordAudit(userActor(r), auditDelete, auditClient, deleted.ID, deleted, nil)
 }
 
 // Allow all domains to be refreshed manually via SSE — streams live progress to the client
@@ -378,16 +434,6 @@ func manRefHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -433,18 +479,8 @@ func tlsAddHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	// Decode the JSON request body
-	var domain DomainReqBody
+	var domain TLSReqBody
 	if err := json.NewDecoder(r.Body).Decode(&domain); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		log.Println(err)
@@ -455,30 +491,94 @@ func tlsAddHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing required fields", http.StatusBadRequest)
 		return
 	}
+	if domain.Protocol == "" {
+		domain.Protocol = tlsDirect
+	}
+	defaultPort, ok := tlsDefaultPorts[domain.Protocol]
+	if !ok {
+		http.Error(w, "Invalid protocol", http.StatusBadRequest)
+		return
+	}
+	if domain.Port == 0 {
+		domain.Port = defaultPort
+	}
+	if domain.Port < 1 || domain.Port > 65535 {
+		http.Error(w, "Invalid port", http.StatusBadRequest)
+		return
+	}
 
-	commonName, validTo, issuer, rawData, err := getTLSCert(domain.Domain)
+	// Only the client's CA bundles can apply to a new entry
+	roots, err := caRoots(TLSDomain{ClientID: domain.ClientID})
+	if err != nil {
+		http.Error(w, "Failed to load CA bundles", http.StatusInternalServerError)
+		log.Println(err)
+		return
+	}
+	endpoint := TLSEndpoint{Host: domain.Domain, Port: domain.Port, SNI: domain.SNI, Protocol: domain.Protocol, Roots: roots}
+	cert, err := getTLSCert(endpoint)
 	if err != nil {
 		http.Error(w, "Failed to fetch TLS certificate", http.StatusInternalServerError)
 		log.Println(err)
 		return
 	}
+	var endpoints []IPCert
+	if domain.PerIP {
+		if endpoints, err = getIPCerts(endpoint); err != nil {
+			http.Error(w, "Failed to resolve the domain's addresses", http.StatusInternalServerError)
+			log.Println(err)
+			return
+		}
+	}
 
 	// Insert the new domain into the DB
-	_, err = db.Exec(context.TODO(), "INSERT INTO crts (domain, commonName, expiration, authority, clientId, rawData, notes) VALUES ($1, $2, $3, $4, $5, $6, $7);",
+	rows, err := db.Query(context.TODO(), `INSERT INTO crts (domain, commonName, expiration, authority, clientId, rawData, notes,
+		dnsNames, ipAddresses, serial, fingerprint, keyType, keyBits, signatureAlgorithm, chain, rootHint, port, sni, protocol, perIP, endpoints, validation)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22) RETURNING *`,
 		domain.Domain,
-		commonName,
-		validTo,
-		issuer,
+		cert.CommonName,
+		cert.Expiration,
+		cert.Authority,
 		domain.ClientID,
-		rawData,
+		cert.RawData,
 		domain.Notes,
+		cert.DNSNames,
+		cert.IPAddresses,
+		cert.Serial,
+		cert.Fingerprint,
+		cert.KeyType,
+		cert.KeyBits,
+		cert.SignatureAlgorithm,
+		cert.Chain,
+		cert.RootHint,
+		domain.Port,
+		nilIfEmpty(domain.SNI),
+		domain.Protocol,
+		domain.PerIP,
+		endpoints,
+		cert.Validation,
 	)
 	if err != nil {
 		log.Print(err)
 		http.Error(w, "Failed to add domain", http.StatusInternalServerError)
 		return
 	}
+	// The unique constraint is checked when the row is read
+	added, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[TLSDomain])
+	if err != nil {
+		// The host is already tracked on this port with this SNI name
+		if isUniqueViolation(err) {
+			http.Error(w, domain.Domain, http.StatusConflict)
+			return
+		}
+		log.Print(err)
+		http.Error(w, "Failed to add domain", http.StatusInternalServerError)
+		return
+	}
+	recordAudit(userActor(r), auditCreate, auditCert, added.ID, nil, auditCertValue(added))
+	// Returned so a certificate that doesn't validate can be pointed out right away
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(auditCertValue(added))
 }
 
 func tlsListHandler(w http.ResponseWriter, r *http.Request) {
@@ -487,18 +587,8 @@ func tlsListHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
-	// Get all rows from the crts SQL table
-	rows, err := db.Query(context.TODO(), "SELECT * FROM crts")
+	// Get all rows from the crts SQL table (only the user's clients for client accounts)
+	rows, err := db.Query(context.TODO(), "SELECT * FROM crts WHERE $1::int[] IS NULL OR clientId = ANY($1)", currentUser(r).clientScope())
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		log.Print(err)
@@ -531,16 +621,6 @@ func deleteTLSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check session token
-	userID, err := checkSessionToken(r)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	} else if userID != 1 {
-		http.Error(w, "Forbidden", http.StatusForbidden)
-		return
-	}
-
 	// Extract the ID from the URL path
 	// Expected format: /api/delete/:id
 	id := strings.Split(r.URL.Path, "/")[3]
@@ -549,17 +629,24 @@ func deleteTLSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := db.Exec(context.TODO(), "DELETE FROM crts WHERE id = $1", id)
+	rows, err := db.Query(context.TODO(), "DELETE FROM crts WHERE id = $1 RETURNING *", id)
 	if err != nil {
 		http.Error(w, "Failed to delete domain", http.StatusInternalServerError)
 		log.Print(err)
 		return
 	}
-	// Make sure the domain was found (and deleted)
-	if c.RowsAffected() == 0 {
-		http.Error(w, "Domain not found", http.StatusNotFound)
+	deleted, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[TLSDomain])
+	if err != nil {
+		// Make sure the domain was found (and deleted)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Domain not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete domain", http.StatusInternalServerError)
+		log.Print(err)
 		return
 	}
+	recordAudit(userActor(r), auditDelete, auditCert, deleted.ID, auditCertValue(deleted), nil)
 }
 
 func main() {
@@ -567,8 +654,9 @@ func main() {
 	db = setupDatabase()
 	defer db.Close()
 
-	// Initialize the database (create tables if they don't exist)
-	InitDBSetup()
+	// Bring the database schema up to date and create the initial user on a fresh install
+	migrateDB()
+	createInitialUser()
 
 	// Backgrounds tasks using a goroutine and ticker
 	// Send weekly expiration reminders and update domain info
@@ -576,15 +664,20 @@ func main() {
 	go func() {
 		ticker := time.NewTicker(24 * time.Hour)
 		for range ticker.C {
-			// Check nameservers every 24 hours
+			// Snapshot the DNS records first so the day's changes are diffed against what was stored,
+			// then refresh the domains due for it (through the same snapshot) and check nameservers every 24 hours
+			takeDNSSnapshots()
+			updateDomains(nil)
 			detectNameserverChanges()
+			checkNameserverConsistency()
+			checkDNSSEC()
+			checkCAA()
 
 			conf := getConfig()
 			// Check if a week has passed since last run
 			if time.Since(conf.LastReminderSent) >= 7*24*time.Hour {
 				log.Println("Running weekly background tasks...")
 				dbCleanup()
-				updateDomains(nil)
 				sendExpDomReminders(nil)
 				updateTLSCerts()
 				sendTLSExpirationReminders()
@@ -599,17 +692,45 @@ func main() {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/login", loginHandler)
-	mux.HandleFunc("/api/get", getHandler)
-	mux.HandleFunc("/api/edit", editHandler)
-	mux.HandleFunc("/api/add", addHandler)
-	mux.HandleFunc("/api/clientList", clientListHandler)
-	mux.HandleFunc("/api/clientAdd", clientAddHandler)
-	mux.HandleFunc("/api/delete/", deleteHandler)
-	mux.HandleFunc("/api/refreshAll", manRefHandler)
-	mux.HandleFunc("/api/deleteClient", deleteClientHandler)
-	mux.HandleFunc("/api/tlsAddDomain", tlsAddHandler)
-	mux.HandleFunc("/api/tlsList", tlsListHandler)
-	mux.HandleFunc("/api/tlsDelete/", deleteTLSHandler)
+	mux.HandleFunc("/api/loginTOTP", loginTOTPHandler)
+	mux.HandleFunc("/api/loginOptions", loginOptionsHandler)
+	mux.HandleFunc("/api/oidc/login", oidcLoginHandler)
+	mux.HandleFunc("/api/oidc/callback", oidcCallbackHandler)
+	mux.HandleFunc("/api/totpSetup", requireRole(RoleClient, totpSetupHandler))
+	mux.HandleFunc("/api/totpEnable", requireRole(RoleClient, totpEnableHandler))
+	mux.HandleFunc("/api/totpDisable", requireRole(RoleClient, totpDisableHandler))
+	mux.HandleFunc("/api/get", requireRole(RoleClient, getHandler))
+	mux.HandleFunc("/api/reminders", requireRole(RoleClient, remindersHandler))
+	mux.HandleFunc("/api/edit", requireRole(RoleEditor, editHandler))
+	mux.HandleFunc("/api/add", requireRole(RoleEditor, addHandler))
+	mux.HandleFunc("/api/clientList", requireRole(RoleClient, clientListHandler))
+	mux.HandleFunc("/api/clientAdd", requireRole(RoleEditor, clientAddHandler))
+	mux.HandleFunc("/api/delete/", requireRole(RoleEditor, deleteHandler))
+	mux.HandleFunc("/api/domainHistory/", requireRole(RoleClient, domainHistoryHandler))
+	mux.HandleFunc("/api/nsChanges", requireRole(RoleClient, nsChangesHandler))
+	mux.HandleFunc("/api/dnsSnapshots/", requireRole(RoleClient, dnsSnapshotsHandler))
+	mux.HandleFunc("/api/dnsDiff", requireRole(RoleClient, dnsDiffHandler))
+	mux.HandleFunc("/api/nsChangeReview", requireRole(RoleAdmin, nsChangeReviewHandler))
+	mux.HandleFunc("/api/refreshAll", requireRole(RoleEditor, manRefHandler))
+	mux.HandleFunc("/api/deleteClient/", requireRole(RoleAdmin, deleteClientHandler))
+	mux.HandleFunc("/api/tlsAddDomain", requireRole(RoleEditor, tlsAddHandler))
+	mux.HandleFunc("/api/tlsList", requireRole(RoleClient, tlsListHandler))
+	mux.HandleFunc("/api/tlsDelete/", requireRole(RoleEditor, deleteTLSHandler))
+	mux.HandleFunc("/api/caBundleList", requireRole(RoleClient, caBundleListHandler))
+	mux.HandleFunc("/api/caBundleAdd", requireRole(RoleEditor, caBundleAddHandler))
+	mux.HandleFunc("/api/caBundleDelete/", requireRole(RoleEditor, caBundleDeleteHandler))
+	mux.HandleFunc("/api/me", requireRole(RoleClient, meHandler))
+	mux.HandleFunc("/api/logout", requireRole(RoleClient, logoutHandler))
+	mux.HandleFunc("/api/sessionList", requireRole(RoleClient, sessionListHandler))
+	mux.HandleFunc("/api/sessionRevoke/", requireRole(RoleClient, sessionRevokeHandler))
+	mux.HandleFunc("/api/userList", requireRole(RoleAdmin, userListHandler))
+	mux.HandleFunc("/api/userAdd", requireRole(RoleAdmin, userAddHandler))
+	mux.HandleFunc("/api/userEdit", requireRole(RoleAdmin, userEditHandler))
+	mux.HandleFunc("/api/loginAttempts", requireRole(RoleAdmin, loginAttemptsHandler))
+	mux.HandleFunc("/api/audit", requireRole(RoleAdmin, auditHandler))
+	mux.HandleFunc("/api/tokenList", requireRole(RoleClient, tokenListHandler))
+	mux.HandleFunc("/api/tokenAdd", requireRole(RoleClient, tokenAddHandler))
+	mux.HandleFunc("/api/tokenRevoke/", requireRole(RoleClient, tokenRevokeHandler))
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "GET" {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -0,0 +1,43 @@
+-- Initial schema, IF NOT EXISTS so installs created before migrations existed are adopted as-is
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password BYTEA NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token VARCHAR(48) NOT NULL,
+	userId INTEGER NOT NULL,
+	expires TIMESTAMPTZ NOT NULL,
+	FOREIGN KEY(userId) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS clients (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS domains (
+	id SERIAL PRIMARY KEY,
+	domain TEXT NOT NULL UNIQUE,
+	expiration TIMESTAMPTZ NOT NULL,
+	nameservers JSONB,
+	registrar TEXT NOT NULL,
+	dns JSON NOT NULL,
+	clientId INTEGER NOT NULL,
+	rawWhoisData JSON NOT NULL,
+	notes TEXT,
+	FOREIGN KEY(clientId) REFERENCES clients(id)
+);
+
+CREATE TABLE IF NOT EXISTS crts (
+	id SERIAL PRIMARY KEY,
+	domain TEXT NOT NULL UNIQUE,
+	commonName TEXT NOT NULL UNIQUE,
+	expiration TIMESTAMPTZ NOT NULL,
+	authority TEXT,
+	clientId INTEGER NOT NULL,
+	rawData TEXT NOT NULL,
+	notes TEXT,
+	FOREIGN KEY(clientId) REFERENCES clients(id)
+);
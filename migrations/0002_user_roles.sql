@@ -0,0 +1,9 @@
+-- Existing users (and the initial user) become admins through the role column default
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'admin';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS user_clients (
+	userId INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	clientId INTEGER NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+	PRIMARY KEY (userId, clientId)
+);
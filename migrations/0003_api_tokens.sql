@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id SERIAL PRIMARY KEY,
+	userId INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	tokenHash BYTEA NOT NULL UNIQUE,
+	scope TEXT NOT NULL,
+	created TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires TIMESTAMPTZ,
+	lastUsed TIMESTAMPTZ
+);
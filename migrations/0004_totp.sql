@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totpSecret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totpEnabled BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totpLastStep BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+	userId INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	codeHash BYTEA NOT NULL,
+	PRIMARY KEY (userId, codeHash)
+);
+
+CREATE TABLE IF NOT EXISTS login_challenges (
+	token VARCHAR(48) PRIMARY KEY,
+	userId INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires TIMESTAMPTZ NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS oidcSubject TEXT UNIQUE;
+
+CREATE TABLE IF NOT EXISTS oidc_states (
+	state VARCHAR(48) PRIMARY KEY,
+	nonce VARCHAR(48) NOT NULL,
+	verifier VARCHAR(48) NOT NULL,
+	expires TIMESTAMPTZ NOT NULL
+);
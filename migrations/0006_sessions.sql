@@ -0,0 +1,5 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS id SERIAL;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS created TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS lastSeen TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS userAgent TEXT;
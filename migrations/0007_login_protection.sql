@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failedLogins INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS lockedUntil TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+	id SERIAL PRIMARY KEY,
+	at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	username TEXT NOT NULL,
+	userId INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	ip TEXT NOT NULL,
+	userAgent TEXT NOT NULL,
+	success BOOLEAN NOT NULL,
+	reason TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS login_attempts_at ON login_attempts (at);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	actor TEXT NOT NULL,
+	userId INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	action TEXT NOT NULL,
+	entity TEXT NOT NULL,
+	entityId INTEGER NOT NULL,
+	before JSONB,
+	after JSONB
+);
+CREATE INDEX IF NOT EXISTS audit_log_entity ON audit_log (entity, entityId);
+
+-- The audit log is append-only
+CREATE OR REPLACE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
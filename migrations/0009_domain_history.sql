@@ -0,0 +1,17 @@
+-- One row per refresh that changed a domain's expiration, registrar or raw RDAP/whois data
+CREATE TABLE domain_history (
+	id SERIAL PRIMARY KEY,
+	domainId INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+	recordedAt TIMESTAMPTZ NOT NULL DEFAULT now(),
+	changes TEXT[] NOT NULL,
+	oldExpiration TIMESTAMPTZ,
+	expiration TIMESTAMPTZ NOT NULL,
+	oldRegistrar TEXT,
+	registrar TEXT NOT NULL,
+	rawWhoisData JSON NOT NULL
+);
+CREATE INDEX domain_history_domain ON domain_history (domainId, recordedAt);
+
+-- Start every existing domain's timeline with its current data
+INSERT INTO domain_history (domainId, changes, expiration, registrar, rawWhoisData)
+	SELECT id, '{tracked}', expiration, registrar, rawWhoisData FROM domains;
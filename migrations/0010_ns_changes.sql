@@ -0,0 +1,13 @@
+-- Nameserver changes found by detectNameserverChanges, reviewed by admins
+CREATE TABLE ns_changes (
+	id SERIAL PRIMARY KEY,
+	domainId INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+	checkedAt TIMESTAMPTZ NOT NULL DEFAULT now(),
+	oldNS JSONB NOT NULL,
+	newNS JSONB NOT NULL,
+	status TEXT NOT NULL DEFAULT 'unreviewed',
+	reviewedBy INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	reviewedAt TIMESTAMPTZ,
+	note TEXT
+);
+CREATE INDEX ns_changes_domain ON ns_changes (domainId, checkedAt);
@@ -0,0 +1,12 @@
+-- Daily DNS snapshots of every domain, changed marks snapshots that differ from the previous one
+CREATE TABLE dns_snapshots (
+	id SERIAL PRIMARY KEY,
+	domainId INTEGER NOT NULL REFERENCES domains(id) ON DELETE CASCADE,
+	takenAt TIMESTAMPTZ NOT NULL DEFAULT now(),
+	dns JSONB NOT NULL,
+	changed BOOLEAN NOT NULL DEFAULT false
+);
+CREATE INDEX dns_snapshots_domain ON dns_snapshots (domainId, takenAt);
+
+-- Start every domain's history with the DNS data we already have
+INSERT INTO dns_snapshots (domainId, dns) SELECT id, dns::jsonb FROM domains;
@@ -0,0 +1,2 @@
+-- Result of the last authoritative nameserver consistency check
+ALTER TABLE domains ADD COLUMN nsCheck JSONB;
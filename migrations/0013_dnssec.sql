@@ -0,0 +1,3 @@
+-- DS records reported by the registry (RDAP secureDNS) and the result of the last DNSSEC validation
+ALTER TABLE domains ADD COLUMN ds JSONB;
+ALTER TABLE domains ADD COLUMN dnssec JSONB;
@@ -0,0 +1,3 @@
+-- CAA policy of tracked domains and TLS hostnames, for certificates with whether their CA is permitted
+ALTER TABLE domains ADD COLUMN caa JSONB;
+ALTER TABLE crts ADD COLUMN caa JSONB;
@@ -0,0 +1,2 @@
+-- EPP status codes from RDAP/whois (clientTransferProhibited, redemptionPeriod, ...)
+ALTER TABLE domains ADD COLUMN status TEXT[];
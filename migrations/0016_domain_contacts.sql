@@ -0,0 +1,6 @@
+-- Registrant and contacts from RDAP/whois, registrantPrivacy is public, redacted, privacy or none (NULL until fetched)
+ALTER TABLE domains ADD COLUMN registrantOrg TEXT;
+ALTER TABLE domains ADD COLUMN registrantCountry TEXT;
+ALTER TABLE domains ADD COLUMN registrantPrivacy TEXT;
+ALTER TABLE domains ADD COLUMN techContact JSONB;
+ALTER TABLE domains ADD COLUMN abuseContact JSONB;
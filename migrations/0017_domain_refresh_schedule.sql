@@ -0,0 +1,4 @@
+-- When a domain's RDAP/whois data was last refreshed and is due next, NULL is due right away
+ALTER TABLE domains ADD COLUMN lastCheckedAt TIMESTAMPTZ;
+ALTER TABLE domains ADD COLUMN nextCheckAt TIMESTAMPTZ;
+CREATE INDEX domains_next_check ON domains (nextCheckAt);
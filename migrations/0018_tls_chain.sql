@@ -0,0 +1,12 @@
+-- Structured certificate details and the full presented chain
+-- SAN-only certificates have no (or a shared) common name, so it can't be unique
+ALTER TABLE crts DROP CONSTRAINT IF EXISTS crts_commonname_key;
+ALTER TABLE crts ADD COLUMN dnsNames TEXT[];
+ALTER TABLE crts ADD COLUMN ipAddresses TEXT[];
+ALTER TABLE crts ADD COLUMN serial TEXT;
+ALTER TABLE crts ADD COLUMN fingerprint TEXT;
+ALTER TABLE crts ADD COLUMN keyType TEXT;
+ALTER TABLE crts ADD COLUMN keyBits INTEGER;
+ALTER TABLE crts ADD COLUMN signatureAlgorithm TEXT;
+ALTER TABLE crts ADD COLUMN chain JSONB;
+ALTER TABLE crts ADD COLUMN rootHint TEXT;
@@ -0,0 +1,7 @@
+-- TLS entries can be on any port, with an SNI override and a STARTTLS protocol
+ALTER TABLE crts ADD COLUMN port INTEGER NOT NULL DEFAULT 443;
+ALTER TABLE crts ADD COLUMN sni TEXT;
+ALTER TABLE crts ADD COLUMN protocol TEXT NOT NULL DEFAULT 'tls';
+-- The same host can be tracked on several ports
+ALTER TABLE crts DROP CONSTRAINT IF EXISTS crts_domain_key;
+ALTER TABLE crts ADD CONSTRAINT crts_endpoint_key UNIQUE (domain, port);
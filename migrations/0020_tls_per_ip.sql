@@ -0,0 +1,3 @@
+-- TLS entries can be checked on every address their host resolves to
+ALTER TABLE crts ADD COLUMN perIP BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE crts ADD COLUMN endpoints JSONB;
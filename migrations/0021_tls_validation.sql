@@ -0,0 +1,2 @@
+-- Result of verifying each certificate (trust, expiry, hostname, revocation)
+ALTER TABLE crts ADD COLUMN validation JSONB;
@@ -0,0 +1,13 @@
+-- Private CA bundles trusted when verifying a client's TLS entries, or a single entry's
+CREATE TABLE IF NOT EXISTS ca_bundles (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	clientId INTEGER REFERENCES clients(id) ON DELETE CASCADE,
+	certId INTEGER REFERENCES crts(id) ON DELETE CASCADE,
+	pem TEXT NOT NULL,
+	certs JSONB NOT NULL,
+	created TIMESTAMPTZ NOT NULL DEFAULT now(),
+	CHECK ((clientId IS NULL) <> (certId IS NULL))
+);
+CREATE INDEX IF NOT EXISTS ca_bundles_client_idx ON ca_bundles (clientId);
+CREATE INDEX IF NOT EXISTS ca_bundles_cert_idx ON ca_bundles (certId);
@@ -0,0 +1,21 @@
+-- The rules made deleting a user with audit entries fail: DO INSTEAD NOTHING also swallowed the
+-- ON DELETE SET NULL of userId. A trigger keeps the log append-only and lets only that through.
+DROP RULE IF EXISTS audit_log_no_update ON audit_log;
+DROP RULE IF EXISTS audit_log_no_delete ON audit_log;
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	-- A deleted user's entries keep the actor name, only the reference is cleared
+	IF TG_OP = 'UPDATE' AND OLD.userId IS NOT NULL AND NEW.userId IS NULL
+		AND NEW.id = OLD.id AND NEW.at = OLD.at AND NEW.actor = OLD.actor AND NEW.action = OLD.action
+		AND NEW.entity = OLD.entity AND NEW.entityId = OLD.entityId
+		AND NEW.before IS NOT DISTINCT FROM OLD.before AND NEW.after IS NOT DISTINCT FROM OLD.after THEN
+		RETURN NEW;
+	END IF;
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
@@ -0,0 +1,3 @@
+-- Where the stored RDAP/whois data (status, contacts) came from: rdap or whois (NULL until fetched)
+-- The two list different things, so a refresh only compares them with data from the same source
+ALTER TABLE domains ADD COLUMN IF NOT EXISTS dataSource TEXT;
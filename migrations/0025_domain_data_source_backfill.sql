@@ -0,0 +1,7 @@
+-- Sets the source of the data stored before dataSource existed, from the raw data kept with it:
+-- RDAP responses are stored with their ObjectClassName, whois as the parsed object
+UPDATE domains SET dataSource = CASE
+    WHEN rawWhoisData->>'ObjectClassName' = 'domain' THEN 'rdap'
+    WHEN json_typeof(rawWhoisData) = 'object' THEN 'whois'
+END
+WHERE dataSource IS NULL;
@@ -0,0 +1,4 @@
+-- The same host can also be tracked on one port with different SNI names
+ALTER TABLE crts DROP CONSTRAINT IF EXISTS crts_endpoint_key;
+DROP INDEX IF EXISTS crts_endpoint_key;
+CREATE UNIQUE INDEX crts_endpoint_key ON crts (domain, port, COALESCE(sni, ''));
@@ -0,0 +1,2 @@
+-- The raw RDAP/whois data is only kept with the entries of a new domain or registrar
+ALTER TABLE domain_history ALTER COLUMN rawWhoisData DROP NOT NULL;
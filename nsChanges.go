@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Review states of a nameserver change
+const (
+	nsChangeUnreviewed = "unreviewed"
+	nsChangeExpected   = "expected"
+	nsChangeSuspicious = "suspicious"
+)
+
+// saveNSChange stores a detected nameserver change, filling in its ID
+func saveNSChange(change *NSChange) error {
+	change.Status = nsChangeUnreviewed
+	return db.QueryRow(context.TODO(), "INSERT INTO ns_changes (domainId, checkedAt, oldNS, newNS) VALUES ($1, $2, $3, $4) RETURNING id",
+		change.DomainID, change.CheckedAt, change.OldNS, change.NewNS).Scan(&change.ID)
+}
+
+// Handle the /api/nsChanges route, lists nameserver changes (newest first)
+// Filters: ?domainID=, ?clientID=, ?status=, ?limit= (default 100)
+func nsChangesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	var domainID, clientID *int
+	for name, dst := range map[string]**int{"domainID": &domainID, "clientID": &clientID} {
+		if v := q.Get(name); v != "" {
+			id, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = &id
+		}
+	}
+	var status *string
+	if v := q.Get("status"); v != "" {
+		status = &v
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "Invalid limit (1-1000)", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	// Client accounts only see changes of their own domains
+	rows, err := db.Query(context.TODO(), `
+		SELECT c.id, c.domainId, d.domain, d.clientId, c.oldNS, c.newNS, c.checkedAt, c.status, u.username AS reviewedby, c.reviewedAt, c.note
+		FROM ns_changes c
+		JOIN domains d ON d.id = c.domainId
+		LEFT JOIN users u ON u.id = c.reviewedBy
+		WHERE ($1::int[] IS NULL OR d.clientId = ANY($1))
+			AND ($2::int IS NULL OR c.domainId = $2)
+			AND ($3::int IS NULL OR d.clientId = $3)
+			AND ($4::text IS NULL OR c.status = $4)
+		ORDER BY c.checkedAt DESC, c.id DESC LIMIT $5`,
+		currentUser(r).clientScope(), domainID, clientID, status, limit)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	changes, err := pgx.CollectRows(rows, pgx.RowToStructByName[NSChange])
+	if err != nil {
+		http.Error(w, "Error reading nameserver changes", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
+// Handle the /api/nsChangeReview route, marks a nameserver change as expected or suspicious
+func nsChangeReviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req NSChangeReviewReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+	if req.ID == 0 {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if req.Status != nsChangeExpected && req.Status != nsChangeSuspicious && req.Status != nsChangeUnreviewed {
+		http.Error(w, "Invalid status", http.StatusBadRequest)
+		return
+	}
+
+	var before struct {
+		Status string  `json:"status"`
+		Note   *string `json:"note,omitempty"`
+	}
+	err := db.QueryRow(context.TODO(), "SELECT status, note FROM ns_changes WHERE id = $1", req.ID).Scan(&before.Status, &before.Note)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Nameserver change not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	user := currentUser(r)
+	_, err = db.Exec(context.TODO(), "UPDATE ns_changes SET status = $1, note = $2, reviewedBy = $3, reviewedAt = now() WHERE id = $4",
+		req.Status, req.Note, user.ID, req.ID)
+	if err != nil {
+		http.Error(w, "Failed to update nameserver change", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	after := before
+	after.Status, after.Note = req.Status, req.Note
+	recordAudit(userActor(r), auditUpdate, auditNSChange, req.ID, before, after)
+}
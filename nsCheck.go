@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/miekg/dns"
+)
+
+// queryAuthoritative sends a non-recursive query straight to a nameserver, with the DO bit set to get the RRSIGs
+func queryAuthoritative(ip, name string, qtype uint16) (*dns.Msg, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = false
+	msg.SetEdns0(4096, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*defaultResolverTimeout)
+	defer cancel()
+	return exchange(ctx, msg, net.JoinHostPort(ip, "53"), resolverUDP, defaultResolverTimeout)
+}
+
+// errNoAddress means a nameserver host doesn't resolve, unlike a failed lookup that makes the delegation lame
+var errNoAddress = errors.New("nameserver has no address")
+
+// nameserverIP returns an address to query a nameserver on, IPv4 preferred
+func nameserverIP(host string) (string, error) {
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := lookup(host, qtype)
+		if err != nil {
+			return "", err
+		}
+		for _, rr := range rrs {
+			switch rr := rr.(type) {
+			case *dns.A:
+				return rr.A.String(), nil
+			case *dns.AAAA:
+				return rr.AAAA.String(), nil
+			}
+		}
+	}
+	return "", errNoAddress
+}
+
+// checkNameserver asks one authoritative nameserver for the domain's SOA and NS records
+func checkNameserver(domain, host string) NSServerCheck {
+	res := NSServerCheck{Host: host}
+
+	ip, err := nameserverIP(host)
+	if err != nil {
+		res.Lame, res.Error = errors.Is(err, errNoAddress), err.Error()
+		return res
+	}
+	res.IP = ip
+
+	soa, err := queryAuthoritative(ip, domain, dns.TypeSOA)
+	if err != nil {
+		res.Lame, res.Error = true, "no response: "+err.Error()
+		return res
+	}
+	// A server that doesn't answer authoritatively for the zone is a lame delegation
+	if soa.Rcode != dns.RcodeSuccess {
+		res.Lame, res.Error = true, "answered "+dns.RcodeToString[soa.Rcode]
+		return res
+	}
+	if !soa.Authoritative {
+		res.Lame, res.Error = true, "not authoritative for the zone"
+		return res
+	}
+	for _, rr := range soa.Answer {
+		if s, ok := rr.(*dns.SOA); ok {
+			res.Serial = &s.Serial
+		}
+	}
+	if res.Serial == nil {
+		res.Lame, res.Error = true, "no SOA record"
+		return res
+	}
+
+	nsMsg, err := queryAuthoritative(ip, domain, dns.TypeNS)
+	if err != nil {
+		res.Error = "NS query failed: " + err.Error()
+		return res
+	}
+	res.NS = []string{}
+	for _, rr := range nsMsg.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			res.NS = append(res.NS, strings.TrimSuffix(strings.ToLower(ns.Ns), "."))
+		}
+	}
+	slices.Sort(res.NS)
+	return res
+}
+
+// checkDomainNameservers queries every nameserver of the delegation and compares their answers
+func checkDomainNameservers(d Domain) NSCheck {
+	delegation := TrimDot(d.Nameservers)
+	for i := range delegation {
+		delegation[i] = strings.ToLower(delegation[i])
+	}
+	slices.Sort(delegation)
+	delegation = slices.Compact(delegation)
+
+	check := NSCheck{CheckedAt: time.Now(), Delegation: delegation, Servers: []NSServerCheck{}, Problems: []string{}}
+	serials := map[uint32][]string{}
+	for _, host := range delegation {
+		res := checkNameserver(d.Domain, host)
+		check.Servers = append(check.Servers, res)
+
+		if res.Lame {
+			check.Problems = append(check.Problems, fmt.Sprintf("Lame delegation: %s (%s)", host, res.Error))
+			continue
+		}
+		// Our own lookup of the nameserver failed, that says nothing about the domain
+		if res.Serial == nil {
+			log.Printf("Failed to check nameserver %s of %s: %s\n", host, d.Domain, res.Error)
+			continue
+		}
+		serials[*res.Serial] = append(serials[*res.Serial], host)
+		if res.NS != nil && !slices.Equal(res.NS, delegation) {
+			check.Problems = append(check.Problems, fmt.Sprintf("%s lists nameservers %s, the delegation is %s",
+				host, strings.Join(res.NS, ", "), strings.Join(delegation, ", ")))
+		}
+	}
+
+	// Secondaries that haven't picked up the latest zone serve an older serial
+	if len(serials) > 1 {
+		var parts []string
+		for serial, hosts := range serials {
+			parts = append(parts, fmt.Sprintf("%d on %s", serial, strings.Join(hosts, ", ")))
+		}
+		slices.Sort(parts)
+		check.Problems = append(check.Problems, "SOA serials differ: "+strings.Join(parts, "; "))
+	}
+	return check
+}
+
+// checkNameserverConsistency runs the authoritative nameserver check for every domain and stores the result
+func checkNameserverConsistency() {
+	rows, err := db.Query(context.TODO(), "SELECT * FROM domains")
+	if err != nil {
+		log.Printf("Failed to get domains: %v\n", err)
+		return
+	}
+	defer rows.Close()
+
+	domains, err := pgx.CollectRows(rows, pgx.RowToStructByName[Domain])
+	if err != nil {
+		log.Printf("Failed to collect domains: %v\n", err)
+		return
+	}
+
+	problems := 0
+	for _, d := range domains {
+		if len(d.Nameservers) == 0 {
+			continue
+		}
+
+		check := checkDomainNameservers(d)
+		if _, err := db.Exec(context.TODO(), "UPDATE domains SET nsCheck = $1 WHERE id = $2", check, d.ID); err != nil {
+			log.Printf("Failed to save nameserver check for domain %s: %v\n", d.Domain, err)
+			continue
+		}
+		updated := d
+		updated.NSCheck = &check
+		recordRefresh("checkNameserverConsistency", auditDomain, d.ID, auditDomainValue(d), auditDomainValue(updated))
+		if len(check.Problems) > 0 {
+			problems++
+			log.Printf("Nameserver problems for %s: %s\n", d.Domain, strings.Join(check.Problems, "; "))
+		}
+	}
+	log.Printf("Checked authoritative nameservers, %d domain(s) with problems\n", problems)
+}
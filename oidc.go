@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// How long the user has to complete the login at the identity provider
+	oidcStateTTL = 10 * time.Minute
+	// Allowed clock difference with the identity provider when checking ID token times
+	oidcClockSkew = time.Minute
+	// How long the discovery document and signing keys are cached
+	oidcCacheTTL = time.Hour
+)
+
+// Subset of the OpenID provider metadata (OpenID Connect Discovery 1.0) we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcJWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// oidcProvider caches the discovery document and signing keys of the configured issuer
+var oidcProvider struct {
+	mu      sync.Mutex
+	issuer  string
+	meta    oidcDiscovery
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+var oidcHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+func oidcRedirectURL() string {
+	return strings.TrimSuffix(getConfig().BaseURL, "/") + "/api/oidc/callback"
+}
+
+// fetchJSON GETs a URL and decodes the JSON response into v
+func fetchJSON(u string, v any) error {
+	res, err := oidcHTTPClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// oidcMetadata returns the provider metadata and signing keys, fetching them if needed.
+// Set refresh to refetch the keys (e.g. the ID token is signed with an unknown key after a key rotation).
+func oidcMetadata(conf *OIDCConfig, refresh bool) (oidcDiscovery, map[string]crypto.PublicKey, error) {
+	oidcProvider.mu.Lock()
+	defer oidcProvider.mu.Unlock()
+
+	if !refresh && oidcProvider.issuer == conf.Issuer && time.Since(oidcProvider.fetched) < oidcCacheTTL {
+		return oidcProvider.meta, oidcProvider.keys, nil
+	}
+
+	var meta oidcDiscovery
+	if err := fetchJSON(strings.TrimSuffix(conf.Issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return oidcDiscovery{}, nil, err
+	}
+	if meta.Issuer != conf.Issuer {
+		return oidcDiscovery{}, nil, fmt.Errorf("discovery issuer %q does not match the configured issuer %q", meta.Issuer, conf.Issuer)
+	}
+
+	var jwks struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	if err := fetchJSON(meta.JWKSURI, &jwks); err != nil {
+		return oidcDiscovery{}, nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("OIDC: skipping key %q: %v\n", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	oidcProvider.issuer = conf.Issuer
+	oidcProvider.meta = meta
+	oidcProvider.keys = keys
+	oidcProvider.fetched = time.Now()
+	return meta, keys, nil
+}
+
+func (k oidcJWK) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) > size || len(y) > size {
+			return nil, errors.New("invalid EC point")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4 // uncompressed
+		copy(point[1+size-len(x):], x)
+		copy(point[1+2*size-len(y):], y)
+		return ecdsa.ParseUncompressedPublicKey(curve, point)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifyJWTSignature checks the signature of a compact JWS with the given key
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match algorithm")
+		}
+		if alg[0] == 'P' {
+			return rsa.VerifyPSS(pub, hash, digest, sig, nil)
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("key type does not match algorithm")
+		}
+		// JWS ECDSA signatures are the raw r || s values
+		if len(sig)%2 != 0 {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:len(sig)/2])
+		s := new(big.Int).SetBytes(sig[len(sig)/2:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// verifyIDToken validates an ID token (signature, issuer, audience, expiry and nonce) and returns its claims
+func verifyIDToken(conf *OIDCConfig, rawToken, nonce string) (map[string]any, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, err
+	}
+	if len(header.Alg) != 5 {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	_, keys, err := oidcMetadata(conf, false)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[header.Kid]
+	if !ok {
+		// The provider may have rotated its keys
+		if _, keys, err = oidcMetadata(conf, true); err != nil {
+			return nil, err
+		}
+		if key, ok = keys[header.Kid]; !ok {
+			return nil, fmt.Errorf("unknown signing key %q", header.Kid)
+		}
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]any
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != conf.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	aud := claimStrings(claims, "aud")
+	if !slices.Contains(aud, conf.ClientID) {
+		return nil, errors.New("ID token not issued for this client")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != conf.ClientID {
+		return nil, errors.New("ID token authorized for another client")
+	}
+	exp, err := claimTime(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(exp.Add(oidcClockSkew)) {
+		return nil, errors.New("ID token expired")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("ID token has no subject")
+	}
+	return claims, nil
+}
+
+// claimStrings returns a claim that can either be a string or an array of strings
+func claimStrings(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func claimTime(claims map[string]any, name string) (time.Time, error) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("missing %s claim", name)
+	}
+	secs, err := n.Float64()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(secs), 0), nil
+}
+
+// oidcExchangeCode exchanges an authorization code (and its PKCE verifier) for the ID token at the token endpoint
+func oidcExchangeCode(conf *OIDCConfig, meta oidcDiscovery, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcRedirectURL())
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", conf.ClientID)
+	req, err := http.NewRequest("POST", meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if conf.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+	}
+	res, err := oidcHTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var tokenRes oidcTokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return "", fmt.Errorf("%s: %w", res.Status, err)
+	}
+	if res.StatusCode != http.StatusOK || tokenRes.IDToken == "" {
+		return "", fmt.Errorf("%s: %s", res.Status, tokenRes.Error)
+	}
+	return tokenRes.IDToken, nil
+}
+
+// oidcRole maps the user's groups to a role, the most privileged mapped group wins.
+// Falls back to the default role (which may be empty: no access).
+func oidcRole(conf *OIDCConfig, groups []string) Role {
+	var role Role
+	for _, g := range groups {
+		if r, ok := conf.RoleMapping[g]; ok && r.rank() > role.rank() {
+			role = r
+		}
+	}
+	if role == "" {
+		role = conf.DefaultRole
+	}
+	return role
+}
+
+// oidcUser finds (or creates) the local user for an identity provider subject and syncs their role
+// Both are audited, the identity provider's groups decide the role without anyone editing the user
+func oidcUser(subject, username string, role Role) (userID int, disabled bool, err error) {
+	var before User
+	err = db.QueryRow(context.TODO(), "SELECT id, username, role, disabled FROM users WHERE oidcSubject = $1", subject).
+		Scan(&before.ID, &before.Username, &before.Role, &before.Disabled)
+	if err == nil {
+		if before.Role != role {
+			if _, err := db.Exec(context.TODO(), "UPDATE users SET role = $1 WHERE id = $2", role, before.ID); err != nil {
+				return 0, false, err
+			}
+			after := before
+			after.Role = role
+			recordAudit(systemActor("oidc"), auditUpdate, auditUser, before.ID, before, after)
+		}
+		return before.ID, before.Disabled, nil
+	}
+	if err != pgx.ErrNoRows {
+		return 0, false, err
+	}
+
+	// New user, give them an unusable password so they can only sign in through the identity provider
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(generateSessionToken()), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, false, err
+	}
+	err = db.QueryRow(context.TODO(), "INSERT INTO users (username, password, role, oidcSubject) VALUES ($1, $2, $3, $4) RETURNING id",
+		username, hashedPassword, role, subject).Scan(&userID)
+	if err != nil {
+		return 0, false, err
+	}
+	recordAudit(systemActor("oidc"), auditCreate, auditUser, userID, nil, User{ID: userID, Username: username, Role: role})
+	return userID, false, nil
+}
+
+// Handle the /api/loginOptions route, tells the login page which sign in methods are available
+func loginOptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginOptions{SSO: getConfig().OIDC.Enabled()})
+}
+
+// Handle the /api/oidc/login route, redirects to the identity provider (authorization code flow with PKCE)
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conf := getConfig().OIDC
+	if !conf.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	meta, _, err := oidcMetadata(conf, false)
+	if err != nil {
+		http.Error(w, "Failed to reach the identity provider", http.StatusBadGateway)
+		log.Print(err)
+		return
+	}
+
+	state := generateSessionToken()
+	nonce := generateSessionToken()
+	verifier := generateSessionToken()
+	_, err = db.Exec(context.TODO(), "INSERT INTO oidc_states (state, nonce, verifier, expires) VALUES ($1, $2, $3, $4)",
+		state, nonce, verifier, time.Now().Add(oidcStateTTL))
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", conf.ClientID)
+	q.Set("redirect_uri", oidcRedirectURL())
+	q.Set("scope", strings.Join(conf.scopes(), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, meta.AuthorizationEndpoint+sep+q.Encode(), http.StatusFound)
+}
+
+// Handle the /api/oidc/callback route, the identity provider redirects back here
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	conf := getConfig().OIDC
+	if !conf.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		http.Error(w, "Sign in failed: "+e, http.StatusForbidden)
+		return
+	}
+
+	// The state can only be used once
+	var nonce, verifier string
+	var expires time.Time
+	err := db.QueryRow(context.TODO(), "DELETE FROM oidc_states WHERE state = $1 RETURNING nonce, verifier, expires", q.Get("state")).Scan(&nonce, &verifier, &expires)
+	if err != nil || time.Now().After(expires) {
+		http.Error(w, "Sign in expired, please try again", http.StatusBadRequest)
+		if err != nil && err != pgx.ErrNoRows {
+			log.Print(err)
+		}
+		return
+	}
+
+	meta, _, err := oidcMetadata(conf, false)
+	if err != nil {
+		http.Error(w, "Failed to reach the identity provider", http.StatusBadGateway)
+		log.Print(err)
+		return
+	}
+
+	idToken, err := oidcExchangeCode(conf, meta, q.Get("code"), verifier)
+	if err != nil {
+		http.Error(w, "Sign in failed", http.StatusForbidden)
+		log.Printf("OIDC: token exchange failed: %v\n", err)
+		return
+	}
+
+	claims, err := verifyIDToken(conf, idToken, nonce)
+	if err != nil {
+		http.Error(w, "Sign in failed", http.StatusForbidden)
+		log.Printf("OIDC: invalid ID token: %v\n", err)
+		return
+	}
+
+	subject := claims["sub"].(string)
+	username, _ := claims[conf.usernameClaim()].(string)
+	if username == "" {
+		username = subject
+	}
+	role := oidcRole(conf, claimStrings(claims, conf.groupsClaim()))
+	if !role.Valid() {
+		recordLoginAttempt(r, username, 0, false, loginSSORejected)
+		http.Error(w, "Your account is not allowed to use Domain Tracker", http.StatusForbidden)
+		log.Printf("OIDC: %s has no mapped role\n", username)
+		return
+	}
+
+	userID, disabled, err := oidcUser(subject, username, role)
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "Username already used by a local account", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if disabled {
+		recordLoginAttempt(r, username, userID, false, loginDisabled)
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
+	if err := createSession(w, r, userID); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordLoginAttempt(r, username, userID, true, loginSSOOK)
+	http.Redirect(w, r, "/dash/", http.StatusFound)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS and a token endpoint handing out one ID token for one code
+type mockIssuer struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	code     string
+	verifier string
+	idToken  string
+}
+
+const mockClientID = "domain-tracker"
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, kid: "key-1", code: "auth-code", verifier: "pkce-verifier"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                m.srv.URL,
+			AuthorizationEndpoint: m.srv.URL + "/authorize",
+			TokenEndpoint:         m.srv.URL + "/token",
+			JWKSURI:               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]oidcJWK{"keys": {{
+			Kty: "RSA",
+			Kid: m.kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.ParseForm() != nil {
+			http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+			return
+		}
+		if r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != m.code ||
+			r.PostForm.Get("code_verifier") != m.verifier || r.PostForm.Get("client_id") != mockClientID {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(oidcTokenResponse{IDToken: m.idToken})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+
+	// oidcRedirectURL reads the base URL from ./config.json
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("config.json", []byte(`{"baseURL":"https://tracker.example"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (m *mockIssuer) conf() *OIDCConfig {
+	return &OIDCConfig{Issuer: m.srv.URL, ClientID: mockClientID}
+}
+
+func (m *mockIssuer) claims(nonce string) map[string]any {
+	return map[string]any{
+		"iss":   m.srv.URL,
+		"aud":   mockClientID,
+		"sub":   "user-123",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+}
+
+// sign builds an RS256 JWT, alg overrides the header's algorithm without changing how it's signed
+func (m *mockIssuer) sign(t *testing.T, key *rsa.PrivateKey, alg string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": m.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m := newMockIssuer(t)
+	conf := m.conf()
+	m.idToken = m.sign(t, m.key, "RS256", m.claims("the-nonce"))
+
+	meta, _, err := oidcMetadata(conf, true)
+	if err != nil {
+		t.Fatalf("discovery: %v", err)
+	}
+	idToken, err := oidcExchangeCode(conf, meta, m.code, m.verifier)
+	if err != nil {
+		t.Fatalf("code exchange: %v", err)
+	}
+	claims, err := verifyIDToken(conf, idToken, "the-nonce")
+	if err != nil {
+		t.Fatalf("valid ID token rejected: %v", err)
+	}
+	if claims["sub"] != "user-123" {
+		t.Errorf("sub = %v, want user-123", claims["sub"])
+	}
+
+	if _, err := oidcExchangeCode(conf, meta, m.code, "wrong-verifier"); err == nil {
+		t.Error("code exchange with the wrong PKCE verifier succeeded")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	m := newMockIssuer(t)
+	conf := m.conf()
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(name string, value any) map[string]any {
+		c := m.claims("the-nonce")
+		c[name] = value
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"bad signature", m.sign(t, otherKey, "RS256", m.claims("the-nonce")), "verification error"},
+		{"alg none", m.sign(t, m.key, "none", m.claims("the-nonce")), "unsupported algorithm"},
+		{"alg HS256", m.sign(t, m.key, "HS256", m.claims("the-nonce")), "unsupported algorithm"},
+		{"alg mismatch", m.sign(t, m.key, "ES256", m.claims("the-nonce")), "key type does not match"},
+		{"wrong issuer", m.sign(t, m.key, "RS256", with("iss", "https://evil.example")), "unexpected issuer"},
+		{"wrong audience", m.sign(t, m.key, "RS256", with("aud", "another-client")), "not issued for this client"},
+		{"expired", m.sign(t, m.key, "RS256", with("exp", time.Now().Add(-10*time.Minute).Unix())), "expired"},
+		{"missing exp", m.sign(t, m.key, "RS256", with("exp", nil)), "missing exp"},
+		{"wrong nonce", m.sign(t, m.key, "RS256", with("nonce", "replayed")), "nonce mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyIDToken(conf, tt.token, "the-nonce")
+			if err == nil {
+				t.Fatal("ID token accepted")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
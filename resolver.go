@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Resolver types selectable in the config
+const (
+	resolverSystem = "system" // the nameservers from /etc/resolv.conf
+	resolverDoH    = "doh"    // DNS-over-HTTPS (RFC 8484 wireformat or JSON)
+	resolverUDP    = "udp"    // direct queries to the configured servers (falls back to TCP for truncated answers)
+	resolverTCP    = "tcp"
+)
+
+const defaultResolverTimeout = 5 * time.Second
+
+// Resolver looks up DNS records
+// A name without records of the type (or that doesn't exist) is not an error, it returns no records
+type Resolver interface {
+	Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error)
+}
+
+// getResolver returns the resolver selected in the config
+// Without a resolver config DNS-over-HTTPS to dns.google is used, like before it was configurable
+func getResolver() Resolver {
+	conf := getConfig().Resolver
+	if conf == nil {
+		conf = &ResolverConfig{Type: resolverDoH}
+	}
+
+	timeout := defaultResolverTimeout
+	if conf.Timeout > 0 {
+		timeout = time.Duration(conf.Timeout) * time.Second
+	}
+
+	switch conf.Type {
+	case resolverDoH:
+		u := conf.URL
+		if u == "" {
+			u = "https://dns.google/dns-query"
+			if conf.Format == "json" {
+				u = "https://dns.google/resolve"
+			}
+		}
+		return &dohResolver{URL: u, JSON: conf.Format == "json", Client: &http.Client{Timeout: timeout}}
+	case resolverUDP, resolverTCP:
+		return &directResolver{Servers: conf.Servers, Net: conf.Type, Timeout: timeout}
+	default:
+		return &directResolver{Net: resolverUDP, Timeout: timeout, System: true}
+	}
+}
+
+// lookup queries the configured resolver for records of one type, CNAMEs and other records in the answer are dropped
+func lookup(name string, qtype uint16) ([]dns.RR, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rrs, err := getResolver().Lookup(ctx, dns.Fqdn(name), qtype)
+	if err != nil {
+		return nil, fmt.Errorf("%s lookup for %s failed: %w", dns.TypeToString[qtype], name, err)
+	}
+
+	var records []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			records = append(records, rr)
+		}
+	}
+	return records, nil
+}
+
+// rrData returns the data part of a record in presentation format (e.g. "10 mx.example.com." for MX)
+func rrData(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// checkRcode turns a failed response into an error, NXDOMAIN just means no records
+func checkRcode(msg *dns.Msg) error {
+	if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+		return fmt.Errorf("server answered %s", dns.RcodeToString[msg.Rcode])
+	}
+	return nil
+}
+
+// directResolver sends queries straight to nameservers over UDP or TCP
+type directResolver struct {
+	Servers []string // host or host:port
+	Net     string   // udp or tcp
+	Timeout time.Duration
+	System  bool // use the nameservers from /etc/resolv.conf
+}
+
+func (r *directResolver) Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	servers := r.Servers
+	if r.System {
+		conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range conf.Servers {
+			servers = append(servers, net.JoinHostPort(s, conf.Port))
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("no DNS servers configured")
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(4096, false)
+
+	// Try each server in turn
+	var errs []error
+	for _, server := range servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resp, err := exchange(ctx, msg, server, r.Net, r.Timeout)
+		if err == nil {
+			err = checkRcode(resp)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server, err))
+			continue
+		}
+		return resp.Answer, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// exchange sends a query to a server, retrying over TCP when a UDP answer is truncated
+func exchange(ctx context.Context, msg *dns.Msg, server, network string, timeout time.Duration) (*dns.Msg, error) {
+	client := &dns.Client{Net: network, Timeout: timeout}
+	resp, _, err := client.ExchangeContext(ctx, msg, server)
+	if err == nil && resp.Truncated && network == resolverUDP {
+		client.Net = resolverTCP
+		resp, _, err = client.ExchangeContext(ctx, msg, server)
+	}
+	return resp, err
+}
+
+// dohResolver queries a DNS-over-HTTPS endpoint
+type dohResolver struct {
+	URL    string
+	JSON   bool // use the JSON API (dns.google/resolve, cloudflare-dns.com/dns-query) instead of RFC 8484 wireformat
+	Client *http.Client
+}
+
+func (r *dohResolver) Lookup(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	if r.JSON {
+		return r.lookupJSON(ctx, name, qtype)
+	}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(name, qtype)
+	msg.SetEdns0(4096, false)
+	// RFC 8484 4.1: use ID 0 for cache friendliness
+	msg.Id = 0
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", r.URL, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	body, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(dns.Msg)
+	if err := resp.Unpack(body); err != nil {
+		return nil, fmt.Errorf("invalid DNS message: %w", err)
+	}
+	if err := checkRcode(resp); err != nil {
+		return nil, err
+	}
+	return resp.Answer, nil
+}
+
+func (r *dohResolver) lookupJSON(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	q := url.Values{"name": {name}, "type": {dns.TypeToString[qtype]}}
+	req, err := http.NewRequestWithContext(ctx, "GET", r.URL+"?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+
+	body, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var res DNSJSONResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	if res.Status != dns.RcodeSuccess && res.Status != dns.RcodeNameError {
+		return nil, fmt.Errorf("server answered %s", dns.RcodeToString[res.Status])
+	}
+
+	// Rebuild the records from their presentation format
+	rrs := make([]dns.RR, 0, len(res.Answer))
+	for _, ans := range res.Answer {
+		// TXT data isn't valid presentation format: Google leaves it unquoted (";" would start a comment, spaces split it)
+		if ans.Type == int(dns.TypeTXT) {
+			rrs = append(rrs, &dns.TXT{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(ans.Name), Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(ans.TTL)},
+				Txt: splitTXT(ans.Data),
+			})
+			continue
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", ans.Name, ans.TTL, dns.TypeToString[uint16(ans.Type)], ans.Data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid record %q: %w", ans.Data, err)
+		}
+		if rr != nil {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, nil
+}
+
+// splitTXT turns the data of a TXT answer from a JSON DoH API into the record's strings
+// Cloudflare quotes each string ("v=spf1 " "-all", with \" and \DDD escapes), Google returns the strings concatenated
+func splitTXT(data string) []string {
+	if !strings.HasPrefix(data, `"`) {
+		// Back into strings of at most 255 bytes, joined they're the same record
+		var parts []string
+		for len(data) > 255 {
+			parts = append(parts, data[:255])
+			data = data[255:]
+		}
+		return append(parts, data)
+	}
+
+	var parts []string
+	var cur []byte
+	quoted := false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case !quoted:
+			if c == '"' {
+				quoted, cur = true, []byte{}
+			}
+		case c == '"':
+			quoted = false
+			parts = append(parts, string(cur))
+		case c == '\\' && i+3 < len(data) && isDigits(data[i+1:i+4]):
+			n, _ := strconv.Atoi(data[i+1 : i+4])
+			cur = append(cur, byte(n))
+			i += 3
+		case c == '\\' && i+1 < len(data):
+			i++
+			cur = append(cur, data[i])
+		default:
+			cur = append(cur, c)
+		}
+	}
+	// An unterminated string is kept rather than dropped
+	if quoted {
+		parts = append(parts, string(cur))
+	}
+	return parts
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *dohResolver) do(req *http.Request) ([]byte, error) {
+	res, err := r.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned %s", res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+}
+
+// ResolveDNS returns the records of a type (e.g. "MX") in presentation format, lowercased
+// No records (or a name that doesn't exist) is an empty slice and a nil error
+func ResolveDNS(domain string, class string) ([]string, error) {
+	qtype, ok := dns.StringToType[class]
+	if !ok {
+		return nil, fmt.Errorf("unknown record type %s", class)
+	}
+	rrs, err := lookup(domain, qtype)
+	if err != nil {
+		return nil, err
+	}
+	records := make([]string, 0, len(rrs))
+	for _, rr := range rrs {
+		records = append(records, strings.ToLower(rrData(rr)))
+	}
+	return records, nil
+}
+
+// resolveDomainDNS looks up the records kept in the domains dns column
+func resolveDomainDNS(domain string) (DNS, error) {
+	var d DNS
+	var errs []error
+	for _, t := range dnsRecordTypes {
+		records, err := ResolveDNS(domain, t)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch t {
+		case "A":
+			d.A = records
+		case "AAAA":
+			d.AAAA = records
+		case "MX":
+			d.MX = records
+		case "NS":
+			d.NS = records
+		}
+	}
+
+	// Only the records above fail the lookup, the mail checks record their own failures
+	d.Mail = checkMailSecurity(domain)
+	return d, errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// Responses as returned by the JSON DoH APIs of Google (dns.google/resolve) and Cloudflare (cloudflare-dns.com/dns-query)
+var dohJSONResponses = map[string]string{
+	"google TXT google.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"google.com.","type":16}],` +
+		`"Answer":[{"name":"google.com.","type":16,"TTL":3600,"data":"v=spf1 include:_spf.google.com ~all"},` +
+		`{"name":"google.com.","type":16,"TTL":3600,"data":"docusign=05958488-4752-4ef2-95eb-aa7ba8a3bd0e"}],"Comment":"Response from 216.239.32.10."}`,
+	"google TXT _dmarc.google.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"_dmarc.google.com.","type":16}],` +
+		`"Answer":[{"name":"_dmarc.google.com.","type":16,"TTL":300,"data":"v=DMARC1; p=reject; rua=mailto:mailauth-reports@google.com"}],"Comment":"Response from 216.239.34.10."}`,
+	"google A google.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"google.com.","type":1}],` +
+		`"Answer":[{"name":"google.com.","type":1,"TTL":300,"data":"142.250.74.46"}],"Comment":"Response from 216.239.38.10."}`,
+	"cloudflare TXT google.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"google.com","type":16}],` +
+		`"Answer":[{"name":"google.com","type":16,"TTL":3600,"data":"\"v=spf1 include:_spf.google.com ~all\""},` +
+		`{"name":"google.com","type":16,"TTL":3600,"data":"\"docusign=05958488-4752-4ef2-95eb-aa7ba8a3bd0e\""}]}`,
+	"cloudflare TXT _dmarc.google.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"_dmarc.google.com","type":16}],` +
+		`"Answer":[{"name":"_dmarc.google.com","type":16,"TTL":300,"data":"\"v=DMARC1; p=reject; rua=mailto:mailauth-reports@google.com\""}]}`,
+	// A DKIM key longer than 255 bytes is split in several strings
+	"cloudflare TXT sel._domainkey.example.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"sel._domainkey.example.com","type":16}],` +
+		`"Answer":[{"name":"sel._domainkey.example.com","type":16,"TTL":300,"data":"\"v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8A\" \"MIIBCgKCAQEAwIDAQAB\""}]}`,
+	"cloudflare A google.com.": `{"Status":0,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false,"Question":[{"name":"google.com","type":1}],` +
+		`"Answer":[{"name":"google.com","type":1,"TTL":300,"data":"142.250.74.46"}]}`,
+}
+
+func dohJSONServer(t *testing.T, provider string) *dohResolver {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := dohJSONResponses[provider+" "+r.URL.Query().Get("type")+" "+r.URL.Query().Get("name")]
+		if !ok {
+			body = `{"Status":3,"TC":false,"RD":true,"RA":true,"AD":false,"CD":false}`
+		}
+		w.Header().Set("Content-Type", "application/dns-json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return &dohResolver{URL: srv.URL, JSON: true, Client: srv.Client()}
+}
+
+func TestDoHJSONTXT(t *testing.T) {
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"google.com.", []string{"v=spf1 include:_spf.google.com ~all", "docusign=05958488-4752-4ef2-95eb-aa7ba8a3bd0e"}},
+		{"_dmarc.google.com.", []string{"v=DMARC1; p=reject; rua=mailto:mailauth-reports@google.com"}},
+	}
+	for _, provider := range []string{"google", "cloudflare"} {
+		r := dohJSONServer(t, provider)
+		for _, tt := range tests {
+			t.Run(provider+" "+tt.name, func(t *testing.T) {
+				rrs, err := r.Lookup(context.Background(), tt.name, dns.TypeTXT)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for _, rr := range rrs {
+					got = append(got, strings.Join(rr.(*dns.TXT).Txt, ""))
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+
+	// Several strings make one record
+	rrs, err := dohJSONServer(t, "cloudflare").Lookup(context.Background(), "sel._domainkey.example.com.", dns.TypeTXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txt := rrs[0].(*dns.TXT).Txt; len(txt) != 2 || strings.Join(txt, "") != "v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAwIDAQAB" {
+		t.Errorf("DKIM strings = %q", txt)
+	}
+}
+
+func TestDoHJSONA(t *testing.T) {
+	for _, provider := range []string{"google", "cloudflare"} {
+		rrs, err := dohJSONServer(t, provider).Lookup(context.Background(), "google.com.", dns.TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rrs) != 1 || rrData(rrs[0]) != "142.250.74.46" {
+			t.Errorf("%s: got %v", provider, rrs)
+		}
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+	}{
+		{`v=spf1 -all`, []string{"v=spf1 -all"}},
+		{`"v=spf1 " "-all"`, []string{"v=spf1 ", "-all"}},
+		{`"say \"hi\"; a\\b\059"`, []string{`say "hi"; a\b;`}},
+		{`""`, []string{""}},
+		{strings.Repeat("a", 300), []string{strings.Repeat("a", 255), strings.Repeat("a", 45)}},
+	}
+	for _, tt := range tests {
+		if got := splitTXT(tt.data); !slices.Equal(got, tt.want) {
+			t.Errorf("splitTXT(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Handle the /api/logout route, ends the current session
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user.SessionID == 0 {
+		http.Error(w, "Not signed in with a session", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Exec(context.TODO(), "DELETE FROM sessions WHERE id = $1", user.SessionID); err != nil {
+		http.Error(w, "Failed to end session", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	setSessionCookie(w, "")
+}
+
+// Handle the /api/sessionList route, lists the user's active sessions
+// Admins can list another user's sessions with ?userID=
+func sessionListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	userID := user.ID
+	if q := r.URL.Query().Get("userID"); q != "" {
+		id, err := strconv.Atoi(q)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		if id != user.ID && user.Role != RoleAdmin {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		userID = id
+	}
+
+	rows, err := db.Query(context.TODO(), "SELECT id, userId, created, lastSeen, expires, ip, userAgent, id = $2 AS current FROM sessions WHERE userId = $1 AND expires > now() ORDER BY lastSeen DESC",
+		userID, user.SessionID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	sessions, err := pgx.CollectRows(rows, pgx.RowToStructByName[SessionInfo])
+	if err != nil {
+		http.Error(w, "Error reading sessions", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// Handle the /api/sessionRevoke/:id route
+func sessionRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract the ID from the URL path
+	// Expected format: /api/sessionRevoke/:id
+	id := strings.Split(r.URL.Path, "/")[3]
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	// Users can revoke their own sessions, admins any session
+	user := currentUser(r)
+	rows, err := db.Query(context.TODO(), "DELETE FROM sessions WHERE id = $1 AND (userId = $2 OR $3) RETURNING id, userId, created, lastSeen, expires, ip, userAgent, id = $4 AS current",
+		id, user.ID, user.Role == RoleAdmin, user.SessionID)
+	if err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	revoked, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[SessionInfo])
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditDelete, auditSession, revoked.ID, revoked, nil)
+}
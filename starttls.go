@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Protocol modes of a TLS entry: how the TLS handshake is reached on the connection
+const (
+	tlsDirect   = "tls"  // TLS right away (HTTPS, SMTPS, IMAPS, LDAPS, ...)
+	tlsSMTP     = "smtp" // SMTP STARTTLS
+	tlsIMAP     = "imap" // IMAP STARTTLS
+	tlsPOP3     = "pop3" // POP3 STLS
+	tlsLDAP     = "ldap" // LDAP StartTLS extended operation
+	tlsPostgres = "postgres"
+	tlsFTP      = "ftp" // FTP AUTH TLS
+)
+
+// Default port of each protocol mode
+var tlsDefaultPorts = map[string]int{
+	tlsDirect:   443,
+	tlsSMTP:     25,
+	tlsIMAP:     143,
+	tlsPOP3:     110,
+	tlsLDAP:     389,
+	tlsPostgres: 5432,
+	tlsFTP:      21,
+}
+
+// startTLS runs the plaintext part of a protocol until the server is ready for the TLS handshake
+func startTLS(conn net.Conn, protocol string) error {
+	r := bufio.NewReader(conn)
+	switch protocol {
+	case tlsDirect:
+		return nil
+	case tlsSMTP:
+		if _, err := readReply(r, "220"); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "EHLO domain-tracker"); err != nil {
+			return err
+		}
+		ehlo, err := readReply(r, "250")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(strings.ToUpper(ehlo), "STARTTLS") {
+			return errors.New("SMTP server doesn't offer STARTTLS")
+		}
+		if err := writeLine(conn, "STARTTLS"); err != nil {
+			return err
+		}
+		_, err = readReply(r, "220")
+		return err
+	case tlsIMAP:
+		if err := expectLine(r, "* OK"); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "a1 STARTTLS"); err != nil {
+			return err
+		}
+		// Skip untagged responses until the tagged one
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a1 ") {
+				if !strings.HasPrefix(strings.ToUpper(line), "A1 OK") {
+					return fmt.Errorf("IMAP STARTTLS refused: %s", strings.TrimSpace(line))
+				}
+				return nil
+			}
+		}
+	case tlsPOP3:
+		if err := expectLine(r, "+OK"); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "STLS"); err != nil {
+			return err
+		}
+		return expectLine(r, "+OK")
+	case tlsFTP:
+		if _, err := readReply(r, "220"); err != nil {
+			return err
+		}
+		if err := writeLine(conn, "AUTH TLS"); err != nil {
+			return err
+		}
+		_, err := readReply(r, "234")
+		return err
+	case tlsPostgres:
+		// SSLRequest: length 8 and the magic code 80877103, the server answers S or N
+		req := make([]byte, 8)
+		binary.BigEndian.PutUint32(req[0:4], 8)
+		binary.BigEndian.PutUint32(req[4:8], 80877103)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != 'S' {
+			return errors.New("PostgreSQL server doesn't support SSL")
+		}
+		return nil
+	case tlsLDAP:
+		return ldapStartTLS(conn, r)
+	}
+	return fmt.Errorf("unknown protocol %q", protocol)
+}
+
+func writeLine(conn net.Conn, line string) error {
+	_, err := conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+func expectLine(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(strings.ToUpper(line), prefix) {
+		return fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// readReply reads a (multiline) SMTP/FTP reply and checks its code
+func readReply(r *bufio.Reader, code string) (string, error) {
+	var reply strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		reply.WriteString(line)
+		if len(line) < 4 || !strings.HasPrefix(line, code) {
+			return "", fmt.Errorf("unexpected response: %s", strings.TrimSpace(line))
+		}
+		// "250-" continues the reply, "250 " ends it
+		if line[3] == ' ' {
+			return reply.String(), nil
+		}
+	}
+}
+
+// ldapStartTLS sends the StartTLS extended operation (RFC 4511 4.14) and checks its result code
+func ldapStartTLS(conn net.Conn, r *bufio.Reader) error {
+	const oid = "1.3.6.1.4.1.1466.20037"
+	// LDAPMessage { messageID 1, ExtendedRequest [APPLICATION 23] { requestName [0] oid } }
+	op := append([]byte{0x80, byte(len(oid))}, oid...)
+	op = append([]byte{0x77, byte(len(op))}, op...)
+	msg := append([]byte{0x02, 0x01, 0x01}, op...)
+	msg = append([]byte{0x30, byte(len(msg))}, msg...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	// LDAPMessage { messageID, ExtendedResponse [APPLICATION 24] { resultCode ENUMERATED, ... } }
+	body, err := readBER(r, 0x30)
+	if err != nil {
+		return err
+	}
+	// Skip the message ID
+	if len(body) < 2 || body[0] != 0x02 || len(body) < 2+int(body[1]) {
+		return errors.New("invalid LDAP response")
+	}
+	resp, err := readBER(bufio.NewReader(bytes.NewReader(body[2+int(body[1]):])), 0x78)
+	if err != nil {
+		return err
+	}
+	if len(resp) < 3 || resp[0] != 0x0a || resp[1] != 0x01 {
+		return errors.New("invalid LDAP response")
+	}
+	if resp[2] != 0 {
+		return fmt.Errorf("LDAP StartTLS refused with result code %d", resp[2])
+	}
+	return nil
+}
+
+// readBER reads one BER element with the expected tag and returns its contents
+func readBER(r *bufio.Reader, tag byte) ([]byte, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if t != tag {
+		return nil, fmt.Errorf("unexpected BER tag 0x%02x", t)
+	}
+	l, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length := int(l)
+	// Long form: the low bits are the number of length bytes
+	if l&0x80 != 0 {
+		n := int(l & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, errors.New("invalid BER length")
+		}
+		length = 0
+		for range n {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 1<<16 {
+		return nil, errors.New("BER element too long")
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(r, buf)
+	return buf, err
+}
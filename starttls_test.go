@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeStep is one exchange of a fake server: it reads what the client must send, then sends its reply
+type fakeStep struct {
+	expect string
+	send   string
+}
+
+// fakeServer plays the steps on its end of the pipe and reports the first mismatch
+func fakeServer(conn net.Conn, steps []fakeStep) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer conn.Close()
+		for _, s := range steps {
+			if s.expect != "" {
+				buf := make([]byte, len(s.expect))
+				if _, err := io.ReadFull(conn, buf); err != nil {
+					done <- fmt.Errorf("waiting for %q: %w", s.expect, err)
+					return
+				}
+				if !bytes.Equal(buf, []byte(s.expect)) {
+					done <- fmt.Errorf("client sent %q, want %q", buf, s.expect)
+					return
+				}
+			}
+			if s.send != "" {
+				if _, err := conn.Write([]byte(s.send)); err != nil {
+					done <- fmt.Errorf("sending %q: %w", s.send, err)
+					return
+				}
+			}
+		}
+		done <- nil
+	}()
+	return done
+}
+
+// LDAP StartTLS request as the client encodes it: messageID 1, ExtendedRequest with the StartTLS OID
+const ldapStartTLSRequest = "\x30\x1d\x02\x01\x01\x77\x18\x80\x16" + "1.3.6.1.4.1.1466.20037"
+
+func TestStartTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		steps    []fakeStep
+		wantErr  string // empty when the upgrade succeeds
+	}{
+		{"direct TLS", tlsDirect, nil, ""},
+		{"SMTP multiline EHLO", tlsSMTP, []fakeStep{
+			{send: "220-mail.example.com ESMTP\r\n220 No UCE\r\n"},
+			{expect: "EHLO domain-tracker\r\n", send: "250-mail.example.com\r\n250-PIPELINING\r\n250-SIZE 10240000\r\n250-STARTTLS\r\n250 8BITMIME\r\n"},
+			{expect: "STARTTLS\r\n", send: "220 2.0.0 Ready to start TLS\r\n"},
+		}, ""},
+		{"SMTP without STARTTLS", tlsSMTP, []fakeStep{
+			{send: "220 mail.example.com ESMTP\r\n"},
+			{expect: "EHLO domain-tracker\r\n", send: "250-mail.example.com\r\n250 8BITMIME\r\n"},
+		}, "doesn't offer STARTTLS"},
+		{"SMTP STARTTLS refused", tlsSMTP, []fakeStep{
+			{send: "220 mail.example.com ESMTP\r\n"},
+			{expect: "EHLO domain-tracker\r\n", send: "250-mail.example.com\r\n250 STARTTLS\r\n"},
+			{expect: "STARTTLS\r\n", send: "454 4.7.0 TLS not available\r\n"},
+		}, "unexpected response: 454"},
+		{"IMAP untagged lines before the tagged reply", tlsIMAP, []fakeStep{
+			{send: "* OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED] Dovecot ready.\r\n"},
+			{expect: "a1 STARTTLS\r\n", send: "* CAPABILITY IMAP4rev1 STARTTLS\r\n* OK Still here\r\na1 OK Begin TLS negotiation now.\r\n"},
+		}, ""},
+		{"IMAP STARTTLS refused", tlsIMAP, []fakeStep{
+			{send: "* OK IMAP4rev1 ready\r\n"},
+			{expect: "a1 STARTTLS\r\n", send: "* BYE going away\r\na1 BAD Unknown command\r\n"},
+		}, "IMAP STARTTLS refused"},
+		{"POP3", tlsPOP3, []fakeStep{
+			{send: "+OK POP3 server ready\r\n"},
+			{expect: "STLS\r\n", send: "+OK Begin TLS negotiation\r\n"},
+		}, ""},
+		{"POP3 STLS refused", tlsPOP3, []fakeStep{
+			{send: "+OK POP3 server ready\r\n"},
+			{expect: "STLS\r\n", send: "-ERR Command not permitted\r\n"},
+		}, "unexpected response: -ERR"},
+		{"FTP multiline banner", tlsFTP, []fakeStep{
+			{send: "220-Welcome to the FTP server\r\n220 Ready\r\n"},
+			{expect: "AUTH TLS\r\n", send: "234 AUTH TLS OK.\r\n"},
+		}, ""},
+		{"LDAP short-form lengths", tlsLDAP, []fakeStep{
+			{expect: ldapStartTLSRequest, send: "\x30\x0c\x02\x01\x01\x78\x07\x0a\x01\x00\x04\x00\x04\x00"},
+		}, ""},
+		{"LDAP long-form lengths", tlsLDAP, []fakeStep{
+			{expect: ldapStartTLSRequest, send: "\x30\x84\x00\x00\x00\x10\x02\x01\x01\x78\x84\x00\x00\x00\x07\x0a\x01\x00\x04\x00\x04\x00"},
+		}, ""},
+		{"LDAP StartTLS refused", tlsLDAP, []fakeStep{
+			{expect: ldapStartTLSRequest, send: "\x30\x81\x0c\x02\x01\x01\x78\x07\x0a\x01\x02\x04\x00\x04\x00"},
+		}, "result code 2"},
+		{"Postgres SSL", tlsPostgres, []fakeStep{
+			{expect: "\x00\x00\x00\x08\x04\xd2\x16\x2f", send: "S"},
+		}, ""},
+		{"Postgres without SSL", tlsPostgres, []fakeStep{
+			{expect: "\x00\x00\x00\x08\x04\xd2\x16\x2f", send: "N"},
+		}, "doesn't support SSL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			done := fakeServer(server, tt.steps)
+			err := startTLS(client, tt.protocol)
+			client.Close()
+			serverErr := <-done
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("startTLS: %v", err)
+				}
+				if serverErr != nil {
+					t.Fatalf("server: %v", serverErr)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("startTLS error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartTLSUnknownProtocol(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	if err := startTLS(client, "gopher"); err == nil {
+		t.Fatal("unknown protocol accepted")
+	}
+}
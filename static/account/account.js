@@ -0,0 +1,118 @@
+function showError(msg) {
+	document.getElementById("error-message").innerHTML = msg;
+	document.getElementById("error-dialog").showModal();
+}
+
+async function loadAccount() {
+	let me = await fetch("/api/me").then((res) => {
+		if (res.ok) {
+			return res.json();
+		} else if (res.status == 401) {
+			if (localStorage.getItem("auth")) localStorage.removeItem("auth");
+			location.assign("/login/");
+		} else {
+			console.error(res);
+			return null;
+		}
+	});
+	if (!me) return;
+
+	document.getElementById("username").textContent = `Signed in as ${me.username} (${me.role})`;
+	document.getElementById("totp-status").textContent = me.totpEnabled ? "Enabled ✓" : "Not enabled";
+	document.getElementById("totp-setup-form").hidden = me.totpEnabled;
+	document.getElementById("totp-disable-form").hidden = !me.totpEnabled;
+	// SSO users don't know their password, they confirm with a code
+	if (me.sso) {
+		for (const id of ["password-label", "password"]) document.getElementById(id).hidden = true;
+		for (const id of ["disable-code-label", "disable-code"]) document.getElementById(id).hidden = false;
+		document.getElementById("password").required = false;
+		document.getElementById("disable-code").required = true;
+	}
+}
+
+async function loadSessions() {
+	let sessions = await fetch("/api/sessionList").then((res) => res.ok ? res.json() : []);
+	const list = document.getElementById("session-list");
+	list.innerHTML = "";
+	sessions.forEach((s) => {
+		let item = document.createElement("li");
+		let info = document.createElement("span");
+		info.textContent = `${s.current ? "This session — " : ""}${s.ip || "Unknown IP"} · ${s.userAgent || "Unknown browser"} · last seen ${new Date(s.lastSeen).toLocaleString()}`;
+		info.title = `Signed in ${new Date(s.created).toLocaleString()}, expires ${new Date(s.expires).toLocaleString()}`;
+		item.appendChild(info);
+
+		let revoke = document.createElement("button");
+		revoke.textContent = s.current ? "Log out" : "Revoke";
+		revoke.addEventListener("click", () => {
+			fetch(`/api/sessionRevoke/${s.id}`, { method: "DELETE" }).then((res) => {
+				if (!res.ok) {
+					showError("Failed to revoke the session.");
+				} else if (s.current) {
+					localStorage.removeItem("auth");
+					location.assign("/login/");
+				} else {
+					loadSessions();
+				}
+			});
+		});
+		item.appendChild(revoke);
+		list.appendChild(item);
+	});
+}
+
+document.getElementById("totp-setup-form").addEventListener("submit", (e) => {
+	e.preventDefault();
+	fetch("/api/totpSetup", { method: "POST" }).then(async (res) => {
+		if (res.ok) {
+			let setup = await res.json();
+			document.getElementById("totp-qr").src = setup.qr;
+			document.getElementById("totp-secret").textContent = setup.secret;
+			document.getElementById("totp-setup-form").hidden = true;
+			document.getElementById("totp-enable-form").hidden = false;
+		} else {
+			showError("Failed to start two-factor setup. <br /> Please try again later.");
+		}
+	});
+});
+
+document.getElementById("totp-enable-form").addEventListener("submit", (e) => {
+	e.preventDefault();
+	fetch("/api/totpEnable", {
+		method: "POST",
+		body: JSON.stringify({ code: document.getElementById("totp-code").value }),
+	}).then(async (res) => {
+		if (res.ok) {
+			let body = await res.json();
+			document.getElementById("totp-enable-form").hidden = true;
+			document.getElementById("recovery-code-list").textContent = body.recoveryCodes.join("\n");
+			document.getElementById("recovery-codes").hidden = false;
+			document.getElementById("totp-status").textContent = "Enabled ✓";
+		} else if (res.status === 403) {
+			showError("Invalid code. <br /> Please try again.");
+		} else {
+			showError("Failed to enable two-factor authentication. <br /> Please try again later.");
+		}
+	});
+});
+
+document.getElementById("totp-disable-form").addEventListener("submit", (e) => {
+	e.preventDefault();
+	fetch("/api/totpDisable", {
+		method: "POST",
+		body: JSON.stringify({ password: document.getElementById("password").value, code: document.getElementById("disable-code").value }),
+	}).then((res) => {
+		if (res.ok) {
+			location.reload();
+		} else if (res.status === 403) {
+			showError(document.getElementById("password").hidden ? "Invalid code." : "Invalid password.");
+		} else {
+			showError("Failed to disable two-factor authentication. <br /> Please try again later.");
+		}
+	});
+});
+
+document.getElementById("close-dialog").addEventListener("click", () => {
+	document.getElementById("error-dialog").close();
+});
+
+loadAccount().then(loadSessions);
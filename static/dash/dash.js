@@ -60,6 +60,7 @@ async function loadDomains() {
 		let client = document.createElement("td");
 		let notes = document.createElement("td");
 		let raw = document.createElement("td");
+		let mail = document.createElement("td");
 		let deleteBtn = document.createElement("span");
 		let edit = document.createElement("span");
 		edit.className = "editIcon";
@@ -77,7 +78,13 @@ async function loadDomains() {
 		domain.appendChild(edit);
 		domain.appendChild(deleteBtn);
 		exp.textContent = new Date(d.expiration).toLocaleDateString();
+		if (d.lastCheckedAt) exp.title = `Last checked ${new Date(d.lastCheckedAt).toLocaleString()}\nNext check ${new Date(d.nextCheckAt).toLocaleString()}`;
 		ns.textContent = d.nameservers ? d.nameservers.join(", ") : "None ❌";
+		if (d.nsCheck && d.nsCheck.problems.length > 0) {
+			ns.textContent = "⚠ " + ns.textContent;
+			ns.title = d.nsCheck.problems.join("\n");
+			ns.classList.add("nsProblem");
+		}
 		let dnsD = d.dns;
 		dnsD.a ? aDNS.textContent = dnsD.a.join(", ") : aDNS.textContent = "None ❌";
 		dnsD.aaaa ? aaaaDNS.textContent = dnsD.aaaa.join(", ") : aaaaDNS.textContent = "None ❌";
@@ -96,6 +103,18 @@ async function loadDomains() {
 		raw.textContent = "View";
 		raw.className = "rawDataBtn";
 		notes.textContent = d.notes ? "View" : "None ❌";
+		if (dnsD.mail) {
+			mail.textContent = dnsD.mail.grade;
+			mail.className = "rawDataBtn";
+			mail.title = (dnsD.mail.issues || []).join("\n");
+			mail.addEventListener("click", () => {
+				document.getElementById("rawDataDiagHeader").textContent = `Email security: ${dnsD.mail.grade} (${dnsD.mail.score}/100)`;
+				document.getElementById("rawData").textContent = JSON.stringify(dnsD.mail, null, 1);
+				document.getElementById("rawDataDiag").showModal();
+			});
+		} else {
+			mail.textContent = "Not checked";
+		}
 
 		if (mxClickable) {
 			mxDNS.classList.add("rawDataBtn");
@@ -174,6 +193,7 @@ async function loadDomains() {
 		row.appendChild(client);
 		row.appendChild(notes);
 		row.appendChild(raw);
+		row.appendChild(mail);
 
 		table.appendChild(row);
 	});
@@ -197,6 +217,11 @@ function main() {
 		}
 	});
 	let search = document.getElementById("searchInput");
+	// Hide the editing controls from users that can't use them
+	fetch("/api/me").then((res) => res.ok ? res.json() : null).then((me) => {
+		if (me && (me.role === "client" || me.role === "readonly"))
+			document.body.classList.add("readOnly");
+	});
 	loadDomains().then(() => {
 		if (searchParms.has("q") && searchParms.get("q").length > 0) {
 			search.value = searchParms.get("q");
@@ -250,6 +275,13 @@ function main() {
 	});
 
 
+	document.getElementById("logout").addEventListener("click", () => {
+		fetch("/api/logout", { method: "POST" }).finally(() => {
+			localStorage.removeItem("auth");
+			location.assign("/login/");
+		});
+	});
+
 	document.getElementById("addD").addEventListener("click", () => {
 		document.getElementById("addDDiag").showModal();
 	});
@@ -0,0 +1,122 @@
+const table = document.querySelector("table");
+const searchParms = new URLSearchParams(location.search);
+let isAdmin = false;
+
+async function loadChanges() {
+	// Remove the rows of a previous load
+	Array.from(table.rows).slice(1).forEach((row) => row.remove());
+
+	let query = new URLSearchParams();
+	if (searchParms.has("domainID")) query.set("domainID", searchParms.get("domainID"));
+	let clientID = document.getElementById("clientFilter").value;
+	if (clientID) query.set("clientID", clientID);
+	let status = document.getElementById("statusFilter").value;
+	if (status) query.set("status", status);
+
+	let changes = await fetch(`/api/nsChanges?${query}`)
+		.then((res) => {
+			if (res.status === 200) {
+				return res.json();
+			} else if (res.status == 401) {
+				alert("Unauthorized");
+				location.assign("/login/");
+			} else {
+				console.error(res);
+				return null;
+			}
+		});
+	if (!changes) {
+		document.querySelector("body").innerHTML = "<h1>An error occurred and Domain tracker is unable to proceed</h1><br /><h2>Please try again later</h2><br /><p>See the browser console for more information</p>";
+		return;
+	}
+
+	if (searchParms.has("domainID") && changes.length > 0) {
+		document.getElementById("domainFilterName").textContent = changes[0].domain;
+		document.getElementById("domainFilter").hidden = false;
+	}
+
+	const clientNames = JSON.parse(sessionStorage.getItem("clientNames") || "{}");
+	changes.forEach((c) => {
+		let row = document.createElement("tr");
+		let detected = document.createElement("td");
+		let domain = document.createElement("td");
+		let client = document.createElement("td");
+		let oldNS = document.createElement("td");
+		let newNS = document.createElement("td");
+		let status = document.createElement("td");
+		let note = document.createElement("td");
+
+		detected.textContent = new Date(c.checkedAt).toLocaleString();
+		let link = document.createElement("a");
+		link.href = `../?q=${c.domain}`;
+		link.textContent = c.domain;
+		domain.appendChild(link);
+		client.textContent = clientNames[c.clientID] || "Unknown";
+		oldNS.textContent = c.oldNS.join(", ");
+		newNS.textContent = c.newNS.join(", ");
+		note.textContent = c.note || "";
+
+		if (isAdmin) {
+			let select = document.createElement("select");
+			["unreviewed", "expected", "suspicious"].forEach((s) => {
+				let option = document.createElement("option");
+				option.value = s;
+				option.textContent = s;
+				select.appendChild(option);
+			});
+			select.value = c.status;
+			select.addEventListener("change", () => {
+				let note = prompt("Note (optional)", c.note || "");
+				fetch("/api/nsChangeReview", {
+					method: "POST",
+					body: JSON.stringify({ id: c.id, status: select.value, note: note || null }),
+				}).then((res) => {
+					if (res.ok) {
+						loadChanges();
+					} else {
+						alert("Error updating nameserver change");
+						select.value = c.status;
+					}
+				});
+			});
+			status.appendChild(select);
+		} else {
+			status.textContent = c.status;
+		}
+		if (c.reviewedBy) status.title = `Reviewed by ${c.reviewedBy} on ${new Date(c.reviewedAt).toLocaleString()}`;
+		status.className = `nsStatus ${c.status}`;
+
+		row.appendChild(detected);
+		row.appendChild(domain);
+		row.appendChild(client);
+		row.appendChild(oldNS);
+		row.appendChild(newNS);
+		row.appendChild(status);
+		row.appendChild(note);
+		table.appendChild(row);
+	});
+}
+
+async function main() {
+	let me = await fetch("/api/me").then((res) => res.ok ? res.json() : null);
+	isAdmin = me && me.role === "admin";
+
+	let clients = await fetch("/api/clientList").then((res) => res.status === 200 ? res.json() : []);
+	let clientNames = {};
+	const clientFilter = document.getElementById("clientFilter");
+	clients.forEach((c) => {
+		clientNames[c.ID] = c.name;
+		let option = document.createElement("option");
+		option.value = c.ID;
+		option.textContent = c.name;
+		clientFilter.appendChild(option);
+	});
+	sessionStorage.setItem("clientNames", JSON.stringify(clientNames));
+	if (searchParms.has("clientID")) clientFilter.value = searchParms.get("clientID");
+
+	clientFilter.addEventListener("change", loadChanges);
+	document.getElementById("statusFilter").addEventListener("change", loadChanges);
+	loadChanges();
+}
+
+main();
@@ -43,6 +43,7 @@ async function loadDomains() {
 		dropdown.appendChild(option);
 	});
 	sessionStorage.setItem("domains", JSON.stringify(domains));
+	loadCABundles(clients, domains);
 	domains.forEach((d) => {
 		let row = document.createElement("tr");
 		let domain = document.createElement("td");
@@ -56,10 +57,39 @@ async function loadDomains() {
 		deleteBtn.title = "Delete";
 		deleteBtn.dataset.id = d.id;
 
-		domain.textContent = d.commonName;
+		domain.textContent = d.commonName || (d.dnsNames && d.dnsNames[0]) || d.domain;
+		if (d.dnsNames && d.dnsNames.length > 0) domain.title = "SANs: " + d.dnsNames.concat(d.ipAddresses || []).join(", ");
+		// Show where the certificate is checked when it isn't plain HTTPS
+		if (d.port != 443 || d.protocol != "tls") {
+			let endpoint = document.createElement("span");
+			endpoint.className = "tlsEndpoint";
+			endpoint.textContent = ` ${d.domain}:${d.port}${d.protocol != "tls" ? " (" + d.protocol + ")" : ""}`;
+			domain.appendChild(endpoint);
+		}
+		if (d.validation && d.validation.status != "valid") {
+			let status = document.createElement("span");
+			status.className = "tlsInvalid";
+			status.textContent = ` ⚠ ${d.validation.status}`;
+			status.title = d.validation.problems.join("\n");
+			domain.appendChild(status);
+		}
 		domain.appendChild(deleteBtn);
 		exp.textContent = new Date(d.expiration).toLocaleDateString();
+		// Per-IP entries: flag addresses serving a different certificate
+		if (d.endpoints && d.endpoints.length > 0) {
+			let served = d.endpoints.filter((e) => !e.error);
+			exp.title = d.endpoints.map((e) => e.error ? `${e.ip}: ${e.error}` : `${e.ip}: serial ${e.serial}, expires ${new Date(e.expiration).toLocaleDateString()}`).join("\n");
+			if (new Set(served.map((e) => e.serial + e.expiration)).size > 1) {
+				exp.textContent = "⚠ " + exp.textContent;
+				exp.classList.add("ipProblem");
+			}
+		}
 		auth.textContent = d.authority;
+		if (d.caa && d.caa.problems.length > 0) {
+			auth.textContent = "⚠ " + auth.textContent;
+			auth.title = d.caa.problems.join("\n");
+			auth.classList.add("caaProblem");
+		}
 		client.textContent = clients.find((c) => c.ID == d.clientID).name;
 		raw.dataset.id = d.id;
 		raw.textContent = "View";
@@ -120,6 +150,50 @@ async function loadDomains() {
 
 }
 
+// List the uploaded CA bundles and fill the scope dropdown of the upload form
+async function loadCABundles(clients, domains) {
+	let scope = document.getElementById("caScope");
+	clients.forEach((c) => {
+		let option = document.createElement("option");
+		option.value = "client:" + c.ID;
+		option.textContent = "Client: " + c.name;
+		scope.appendChild(option);
+	});
+	domains.forEach((d) => {
+		let option = document.createElement("option");
+		option.value = "cert:" + d.id;
+		option.textContent = `Website: ${d.domain}:${d.port}`;
+		scope.appendChild(option);
+	});
+
+	let bundles = await fetch("/api/caBundleList").then((res) => res.ok ? res.json() : []);
+	let list = document.getElementById("caBundleList");
+	(bundles || []).forEach((b) => {
+		let item = document.createElement("li");
+		let target = b.clientID ? "Client: " + clients.find((c) => c.ID == b.clientID).name : "Website: " + (domains.find((d) => d.id == b.certID) || {}).domain;
+		let expires = b.certs.map((c) => new Date(c.notAfter)).sort((a, b) => a - b)[0];
+		item.textContent = `${b.name} (${target}) · ${b.certs.length} certificate(s), first expiring ${expires.toLocaleDateString()}`;
+		item.title = b.certs.map((c) => `${c.subject}, expires ${new Date(c.notAfter).toLocaleDateString()}`).join("\n");
+		if (expires < new Date()) item.classList.add("caExpired");
+		let deleteBtn = document.createElement("span");
+		deleteBtn.className = "deleteIcon";
+		deleteBtn.title = "Delete";
+		deleteBtn.addEventListener("click", () => {
+			if (!confirm("Are you sure you want to delete this CA bundle?")) return;
+			fetch(`/api/caBundleDelete/${b.id}`, { method: "DELETE" }).then((res) => {
+				if (res.ok) {
+					alert("CA bundle deleted");
+					location.reload();
+				} else {
+					alert("Error deleting CA bundle");
+				}
+			});
+		});
+		item.appendChild(deleteBtn);
+		list.appendChild(item);
+	});
+}
+
 function main() {
 	if (window.matchMedia("(max-width: 850px)").matches) {
 		document.querySelector("body").innerHTML = "<h1>Your screen size doesn't meet the minimum requirements</h1>";
@@ -136,6 +210,11 @@ function main() {
 		}
 	});
 	let search = document.getElementById("searchInput");
+	// Hide the editing controls from users that can't use them
+	fetch("/api/me").then((res) => res.ok ? res.json() : null).then((me) => {
+		if (me && (me.role === "client" || me.role === "readonly"))
+			document.body.classList.add("readOnly");
+	});
 	loadDomains().then(() => {
 		if (searchParms.has("q") && searchParms.get("q").length > 0) {
 			search.value = searchParms.get("q");
@@ -153,16 +232,25 @@ function main() {
 		document.getElementById('addDDiag').close();
 		let domain = document.getElementById("domain").value;
 		let notes = document.getElementById("notes").value;
+		let protocol = document.getElementById("protocol").value;
+		let port = parseInt(document.getElementById("port").value) || 0;
+		let sni = document.getElementById("sni").value;
+		let perIP = document.getElementById("perIP").checked;
 		document.getElementById("addDForm").reset();
 		fetch("/api/tlsAddDomain", {
 			method: "POST",
-			body: JSON.stringify({ domain, clientId: parseInt(clientId), notes }),
+			body: JSON.stringify({ domain, clientId: parseInt(clientId), notes, protocol, port, sni, perIP }),
 		}).then(async (res) => {
 			if (res.ok) {
-				alert("Domain added");
+				let added = await res.json();
+				if (added.validation && added.validation.status != "valid") {
+					alert("Domain added\nThe certificate doesn't validate:\n" + added.validation.problems.join("\n"));
+				} else {
+					alert("Domain added");
+				}
 				location.reload();
 			} else if (res.status === 409) {
-				alert("Domain already exists\nThis domain may share a certificate common name with another domain");
+				alert("Domain already exists\nThis domain is already tracked on this port");
 				location.assign(`./?q=${await res.text()}`);
 				
 			} else {
@@ -191,6 +279,34 @@ function main() {
 	});
 
 
+	document.getElementById("caFile").addEventListener("change", async (e) => {
+		if (e.target.files.length > 0) document.getElementById("caPem").value = await e.target.files[0].text();
+	});
+	document.getElementById("addCAForm").addEventListener("submit", (e) => {
+		e.preventDefault();
+		let [kind, id] = document.getElementById("caScope").value.split(":");
+		if (!id) {
+			alert("Please select a client or website");
+			return;
+		}
+		let body = { name: document.getElementById("caName").value, pem: document.getElementById("caPem").value };
+		body[kind === "client" ? "clientID" : "certID"] = parseInt(id);
+		fetch("/api/caBundleAdd", {
+			method: "POST",
+			body: JSON.stringify(body),
+		}).then(async (res) => {
+			if (res.ok) {
+				alert("CA bundle uploaded\nThe affected websites are being verified again");
+				location.reload();
+			} else {
+				alert("Error uploading CA bundle\n" + await res.text());
+			}
+		});
+	});
+
+	document.getElementById("caBundles").addEventListener("click", () => {
+		document.getElementById("caBundlesDiag").showModal();
+	});
 	document.getElementById("addD").addEventListener("click", () => {
 		document.getElementById("addDDiag").showModal();
 	});
@@ -2,18 +2,40 @@ if (localStorage.getItem("auth")) {
 	location.assign("/dash/");
 } else {
 	const searchParms = new URLSearchParams(location.search);
-	document.querySelector("form").addEventListener("submit", (e) => {
+	let challenge = null;
+
+	fetch("/api/loginOptions").then((res) => res.ok ? res.json() : {}).then((opts) => {
+		document.getElementById("sso-login").hidden = !opts.sso;
+	});
+
+	function loggedIn() {
+		localStorage.setItem("auth", true);
+		if (searchParms.has("q") && searchParms.get("q").length > 0) 
+			location.assign(`/dash/?q=${searchParms.get("q")}`);
+		else
+			location.assign("/dash/");
+	}
+
+	document.getElementById("login-form").addEventListener("submit", (e) => {
 		e.preventDefault();
 		fetch("/api/login", {
 			method: "POST",
 			body: JSON.stringify({ username: document.getElementById("username").value, password: document.getElementById("password").value })
-		}).then(res => {
+		}).then(async res => {
 			if (res.ok) {
-				localStorage.setItem("auth", true);
-				if (searchParms.has("q") && searchParms.get("q").length > 0) 
-					location.assign(`/dash/?q=${searchParms.get("q")}`);
-				else
-					location.assign("/dash/");
+				// Two-factor authentication: ask for the code before signing in
+				let body = res.headers.get("Content-Type") === "application/json" ? await res.json() : {};
+				if (body.totpRequired) {
+					challenge = body.challenge;
+					document.getElementById("login-form").hidden = true;
+					document.getElementById("totp-form").hidden = false;
+					document.getElementById("totp-code").focus();
+					return;
+				}
+				loggedIn();
+			} else if (res.status === 429 || res.status === 423) {
+				document.getElementById("error-message").innerHTML = "Too many failed sign in attempts. <br /> Please wait and try again later.";
+				document.getElementById("error-dialog").showModal();
 			} else if (res.status === 403 || res.status === 401) {
 				document.getElementById("error-message").innerHTML = "Invalid username or password. <br /> Please try again.";
 				document.getElementById("error-dialog").showModal();
@@ -23,6 +45,34 @@ if (localStorage.getItem("auth")) {
 			}
 		});
 	});
+
+	document.getElementById("totp-form").addEventListener("submit", (e) => {
+		e.preventDefault();
+		fetch("/api/loginTOTP", {
+			method: "POST",
+			body: JSON.stringify({ challenge, code: document.getElementById("totp-code").value })
+		}).then(res => {
+			if (res.ok) {
+				loggedIn();
+			} else if (res.status === 401) {
+				// Challenge expired or used up, start over
+				document.getElementById("error-message").innerHTML = "Your sign in expired. <br /> Please sign in again.";
+				document.getElementById("error-dialog").showModal();
+				document.getElementById("totp-form").reset();
+				document.getElementById("totp-form").hidden = true;
+				document.getElementById("login-form").hidden = false;
+			} else if (res.status === 429 || res.status === 423) {
+				document.getElementById("error-message").innerHTML = "Too many failed sign in attempts. <br /> Please wait and try again later.";
+				document.getElementById("error-dialog").showModal();
+			} else if (res.status === 403) {
+				document.getElementById("error-message").innerHTML = "Invalid code. <br /> Please try again.";
+				document.getElementById("error-dialog").showModal();
+			} else {
+				document.getElementById("error-message").innerHTML = "Something went wrong. <br /> Please try again later.";
+				document.getElementById("error-dialog").showModal();
+			}
+		});
+	});
 	console.info("Made with ❤️ by @1alphabyte https://github.com/1alphabyte");
 }
 
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// keyInfo returns the type and size of a certificate's public key
+func keyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// chainCert describes one certificate of a presented chain
+func chainCert(cert *x509.Certificate) ChainCert {
+	keyType, keyBits := keyInfo(cert)
+	return ChainCert{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		Serial:             fmt.Sprintf("%X", cert.SerialNumber),
+		Fingerprint:        certFingerprint(cert),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		KeyType:            keyType,
+		KeyBits:            keyBits,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		CA:                 cert.IsCA,
+		SelfSigned:         bytes.Equal(cert.RawSubject, cert.RawIssuer),
+	}
+}
+
+// TLSEndpoint is where and how a certificate is fetched
+type TLSEndpoint struct {
+	Host     string
+	Port     int
+	SNI      string         // server name sent and verified, the host when empty
+	Protocol string         // see starttls.go
+	Addr     string         // address to connect to instead of resolving the host
+	Roots    *x509.CertPool // trusted roots, the system's when nil
+}
+
+func (e TLSEndpoint) serverName() string {
+	if e.SNI != "" {
+		return e.SNI
+	}
+	return e.Host
+}
+
+// tlsEndpoint returns the endpoint of a tracked certificate
+func tlsEndpoint(c TLSDomain) TLSEndpoint {
+	e := TLSEndpoint{Host: c.Domain, Port: c.Port, Protocol: c.Protocol}
+	if c.SNI != nil {
+		e.SNI = *c.SNI
+	}
+	return e
+}
+
+const tlsTimeout = 15 * time.Second
+
+// getTLSCert connects to an endpoint, negotiates TLS (after STARTTLS for the protocols using it) and describes the certificate chain presented
+func getTLSCert(e TLSEndpoint) (TLSCertData, error) {
+	addr := e.Host
+	if e.Addr != "" {
+		addr = e.Addr
+	}
+	raw, err := net.DialTimeout("tcp", net.JoinHostPort(addr, strconv.Itoa(e.Port)), tlsTimeout)
+	if err != nil {
+		return TLSCertData{}, err
+	}
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(tlsTimeout))
+
+	if err := startTLS(raw, e.Protocol); err != nil {
+		return TLSCertData{}, fmt.Errorf("%s negotiation with %s failed: %w", e.Protocol, e.Host, err)
+	}
+	// Verification is done afterwards, so certificates that fail it are still described
+	conn := tls.Client(raw, &tls.Config{ServerName: e.serverName(), InsecureSkipVerify: true})
+	if err := conn.Handshake(); err != nil {
+		return TLSCertData{}, err
+	}
+
+	// The leaf comes first, followed by the intermediates (and sometimes the root)
+	certs := conn.ConnectionState().PeerCertificates
+	cert := certs[0]
+	certJSON, err := json.Marshal(cert)
+	if err != nil {
+		return TLSCertData{}, err
+	}
+
+	data := TLSCertData{
+		CommonName:  cert.Subject.CommonName,
+		Expiration:  cert.NotAfter,
+		Authority:   cert.Issuer.CommonName,
+		RawData:     certJSON,
+		DNSNames:    cert.DNSNames,
+		IPAddresses: []string{},
+		Chain:       []ChainCert{},
+	}
+	if data.DNSNames == nil {
+		data.DNSNames = []string{}
+	}
+	for _, ip := range cert.IPAddresses {
+		data.IPAddresses = append(data.IPAddresses, ip.String())
+	}
+	for _, c := range certs {
+		data.Chain = append(data.Chain, chainCert(c))
+	}
+	leaf := data.Chain[0]
+	data.Serial, data.Fingerprint, data.KeyType, data.KeyBits, data.SignatureAlgorithm = leaf.Serial, leaf.Fingerprint, leaf.KeyType, leaf.KeyBits, leaf.SignatureAlgorithm
+
+	// The root the chain leads to, servers usually leave it out
+	last := certs[len(certs)-1]
+	if bytes.Equal(last.RawSubject, last.RawIssuer) {
+		data.RootHint = last.Subject.String()
+	} else {
+		data.RootHint = last.Issuer.String()
+	}
+	// An IP address isn't sent as SNI, it's still what the certificate is verified against
+	data.Validation = validateCert(certs, e.serverName(), e.Roots, conn.ConnectionState().OCSPResponse)
+	return data, nil
+}
+
+// chainProblems lists the intermediates that expire before the leaf, the site breaks when they do even with a valid leaf
+func chainProblems(chain []ChainCert) []string {
+	var problems []string
+	for i, c := range chain {
+		if i == 0 || c.SelfSigned {
+			continue
+		}
+		if c.NotAfter.Before(chain[0].NotAfter) {
+			problems = append(problems, fmt.Sprintf("Intermediate %s expires %s, before the certificate (%s)",
+				c.Subject, c.NotAfter.Format("01/02/2006"), chain[0].NotAfter.Format("01/02/2006")))
+		}
+	}
+	return problems
+}
+
+// certName is how a tracked certificate is shown, SAN-only certificates have no common name
+func certName(c TLSDomain) string {
+	if c.CommonName != "" {
+		return c.CommonName
+	}
+	if len(c.DNSNames) > 0 {
+		return c.DNSNames[0]
+	}
+	return c.Domain
+}
+
+// saveTLSCert stores a freshly fetched certificate of a tracked entry
+func saveTLSCert(id int, data TLSCertData) (TLSDomain, error) {
+	rows, err := db.Query(context.TODO(), `UPDATE crts SET expiration = $1, authority = $2, rawData = $3, commonName = $4, dnsNames = $5, ipAddresses = $6,
+		serial = $7, fingerprint = $8, keyType = $9, keyBits = $10, signatureAlgorithm = $11, chain = $12, rootHint = $13,
+		validation = $14 WHERE id = $15 RETURNING *`,
+		data.Expiration, data.Authority, data.RawData, data.CommonName, data.DNSNames, data.IPAddresses,
+		data.Serial, data.Fingerprint, data.KeyType, data.KeyBits, data.SignatureAlgorithm, data.Chain, data.RootHint, data.Validation, id)
+	if err != nil {
+		return TLSDomain{}, err
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[TLSDomain])
+}
+
+type chainAlert struct {
+	Cert     TLSDomain
+	Problems []string
+}
+
+func sendChainAlerts(alerts []chainAlert) {
+	var list string
+	for _, a := range alerts {
+		subtitle := fmt.Sprintf("Expires %s &middot; Authority: %s", a.Cert.Expiration.Format("01/02/2006"), a.Cert.Authority)
+		list += domainCard("#e3b341", getConfig().BaseURL+"/dash/tls/?q="+certName(a.Cert), certName(a.Cert), subtitle, strings.Join(a.Problems, "<br>"))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The chain of <strong>%d certificate(s)</strong> has an intermediate that expires before the certificate itself. Install the current intermediate before it expires.</p>`, len(alerts))
+	if err := sendEmail("Certificate chain problems detected", emailHTML("Certificate chain problems detected", intro+list)); err != nil {
+		log.Printf("Failed to send certificate chain alert email: %v\n", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Handle the /api/tokenList route, lists the user's API tokens (every token for admins)
+func tokenListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	rows, err := db.Query(context.TODO(), "SELECT id, userId, name, scope, created, expires, lastUsed FROM api_tokens WHERE userId = $1 OR $2 ORDER BY id",
+		user.ID, user.Role == RoleAdmin)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	tokens, err := pgx.CollectRows(rows, pgx.RowToStructByName[APIToken])
+	if err != nil {
+		http.Error(w, "Error reading tokens", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Handle the /api/tokenAdd route
+// The token itself is only returned here, the database only keeps its hash
+func tokenAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user.Scope != "" {
+		http.Error(w, "API tokens cannot create tokens", http.StatusForbidden)
+		return
+	}
+
+	var req TokenReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = ScopeFull
+	}
+	if !req.Scope.Valid() || req.ExpiresInDays < 0 {
+		http.Error(w, "Invalid scope or expiry", http.StatusBadRequest)
+		return
+	}
+
+	// Only admins can create tokens for other (service) users
+	if req.UserID == 0 {
+		req.UserID = user.ID
+	} else if req.UserID != user.ID && user.Role != RoleAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var expires *time.Time
+	if req.ExpiresInDays > 0 {
+		exp := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expires = &exp
+	}
+
+	res := NewTokenResponse{Token: "dt_" + generateSessionToken()}
+	err := db.QueryRow(context.TODO(), "INSERT INTO api_tokens (userId, name, tokenHash, scope, expires) VALUES ($1, $2, $3, $4, $5) RETURNING id, userId, name, scope, created, expires",
+		req.UserID, req.Name, hashAPIToken(res.Token), req.Scope, expires).
+		Scan(&res.ID, &res.UserID, &res.Name, &res.Scope, &res.Created, &res.Expires)
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditCreate, auditAPIToken, res.ID, nil, res.APIToken)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// Handle the /api/tokenRevoke/:id route
+func tokenRevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract the ID from the URL path
+	// Expected format: /api/tokenRevoke/:id
+	id := strings.Split(r.URL.Path, "/")[3]
+	if id == "" {
+		http.Error(w, "Missing ID", http.StatusBadRequest)
+		return
+	}
+
+	// Users can revoke their own tokens, admins any token
+	user := currentUser(r)
+	rows, err := db.Query(context.TODO(), "DELETE FROM api_tokens WHERE id = $1 AND (userId = $2 OR $3) RETURNING id, userId, name, scope, created, expires, lastUsed",
+		id, user.ID, user.Role == RoleAdmin)
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	revoked, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[APIToken])
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditDelete, auditAPIToken, revoked.ID, revoked, nil)
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
+)
+
+// TOTP parameters (RFC 6238 defaults, what every authenticator app supports)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// Accept codes from one step before/after the current one to allow for clock drift
+	totpSkew = 1
+
+	recoveryCodeCount = 10
+	// A login challenge (password accepted, waiting for the TOTP code) is valid this long
+	loginChallengeTTL = 5 * time.Minute
+	// Wrong codes allowed per login challenge before the user has to sign in again
+	loginChallengeAttempts = 5
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateTOTPSecret() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return totpEncoding.EncodeToString(b)
+}
+
+// totpCode returns the code for the given time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// matchTOTP returns the time step the code is valid for, or 0 if it isn't valid now
+func matchTOTP(secret, code string) int64 {
+	now := time.Now().Unix() / totpPeriod
+	for step := now - totpSkew; step <= now+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			log.Print(err)
+			return 0
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step
+		}
+	}
+	return 0
+}
+
+func totpURI(username, secret string) string {
+	issuer := "Domain Tracker"
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("period", fmt.Sprint(totpPeriod))
+	v.Set("digits", fmt.Sprint(totpDigits))
+	// Some authenticator apps don't decode "+" as a space
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + strings.ReplaceAll(v.Encode(), "+", "%20")
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code, recovery codes are random so a fast hash is enough
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
+
+func generateRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, _ = rand.Read(b)
+		code := strings.ToLower(totpEncoding.EncodeToString(b)) // 8 characters
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+	return codes
+}
+
+// verifyUserTOTP checks a TOTP code (or an unused recovery code) for a user with two-factor authentication enabled.
+// Codes can only be used once.
+func verifyUserTOTP(userID int, code string) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := db.QueryRow(context.TODO(), "SELECT totpSecret, totpLastStep FROM users WHERE id = $1 AND totpEnabled", userID).Scan(&secret, &lastStep)
+	if err != nil {
+		return false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if step := matchTOTP(secret, code); step > lastStep {
+		// Store the step so the same code can't be replayed
+		c, err := db.Exec(context.TODO(), "UPDATE users SET totpLastStep = $1 WHERE id = $2 AND totpLastStep < $1", step, userID)
+		if err != nil {
+			return false, err
+		}
+		return c.RowsAffected() == 1, nil
+	}
+
+	c, err := db.Exec(context.TODO(), "DELETE FROM totp_recovery_codes WHERE userId = $1 AND codeHash = $2", userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	return c.RowsAffected() == 1, nil
+}
+
+// createLoginChallenge stores a pending login for a user that still needs to enter a TOTP code
+func createLoginChallenge(userID int) (string, error) {
+	token := generateSessionToken()
+	_, err := db.Exec(context.TODO(), "INSERT INTO login_challenges (token, userId, expires) VALUES ($1, $2, $3)", token, userID, time.Now().Add(loginChallengeTTL))
+	return token, err
+}
+
+// Handle the /api/loginTOTP route, the second login step for users with two-factor authentication
+func loginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LoginTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.Challenge == "" || req.Code == "" {
+		http.Error(w, "Missing credentials", http.StatusUnauthorized)
+		return
+	}
+
+	var userID int
+	var username string
+	var expires time.Time
+	var disabled bool
+	var lockedUntil *time.Time
+	err := db.QueryRow(context.TODO(), "SELECT c.userId, u.username, c.expires, u.disabled, u.lockedUntil FROM login_challenges c JOIN users u ON u.id = c.userId WHERE c.token = $1", req.Challenge).
+		Scan(&userID, &username, &expires, &disabled, &lockedUntil)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			recordLoginAttempt(r, "", 0, false, loginChallengeGone)
+			http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if time.Now().After(expires) {
+		recordLoginAttempt(r, username, userID, false, loginChallengeGone)
+		http.Error(w, "Login expired, please sign in again", http.StatusUnauthorized)
+		return
+	}
+	// The account may have been disabled or locked since the password step
+	if lockedUntil != nil && time.Now().Before(*lockedUntil) {
+		recordLoginAttempt(r, username, userID, false, loginLocked)
+		rejectLocked(w, *lockedUntil)
+		return
+	}
+	if disabled {
+		recordLoginAttempt(r, username, userID, false, loginDisabled)
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
+	// Wrong codes count towards the same limits as wrong passwords
+	ip := clientIP(r)
+	if wait := loginRetryAfter(ip, username); wait > 0 {
+		recordLoginAttempt(r, username, userID, false, loginRateLimited)
+		rejectRateLimited(w, wait)
+		return
+	}
+
+	ok, err := verifyUserTOTP(userID, req.Code)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if !ok {
+		loginFailed(ip, username)
+		accountFailedLogin(userID)
+		recordLoginAttempt(r, username, userID, false, loginBadCode)
+
+		// Give up on the challenge after too many wrong codes
+		_, err := db.Exec(context.TODO(), "UPDATE login_challenges SET attempts = attempts + 1 WHERE token = $1", req.Challenge)
+		if err == nil {
+			_, err = db.Exec(context.TODO(), "DELETE FROM login_challenges WHERE token = $1 AND attempts >= $2", req.Challenge, loginChallengeAttempts)
+		}
+		if err != nil {
+			log.Print(err)
+		}
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	if _, err := db.Exec(context.TODO(), "DELETE FROM login_challenges WHERE token = $1", req.Challenge); err != nil {
+		log.Print(err)
+	}
+
+	loginSucceeded(ip, username)
+	accountLoginOK(userID)
+	if err := createSession(w, r, userID); err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordLoginAttempt(r, username, userID, true, loginOK)
+}
+
+// Handle the /api/totpSetup route
+// Generates a new (not yet enabled) TOTP secret for the user, enabled with /api/totpEnable
+func totpSetupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user.Scope != "" {
+		http.Error(w, "API tokens cannot manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+
+	secret := generateTOTPSecret()
+	c, err := db.Exec(context.TODO(), "UPDATE users SET totpSecret = $1 WHERE id = $2 AND NOT totpEnabled", secret, user.ID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if c.RowsAffected() == 0 {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	res := TOTPSetupResponse{Secret: secret, URI: totpURI(user.Username, secret)}
+	code, err := qr.Encode(res.URI, qr.M)
+	if err != nil {
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	res.QR = "data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// Handle the /api/totpEnable route
+// Confirms the secret from /api/totpSetup with a code and returns the recovery codes (only shown once)
+func totpEnableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user.Scope != "" {
+		http.Error(w, "API tokens cannot manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+
+	var req TOTPReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var secret *string
+	var enabled bool
+	err := db.QueryRow(context.TODO(), "SELECT totpSecret, totpEnabled FROM users WHERE id = $1", user.ID).Scan(&secret, &enabled)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if enabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+	if secret == nil {
+		http.Error(w, "Run the two-factor setup first", http.StatusBadRequest)
+		return
+	}
+
+	step := matchTOTP(*secret, strings.TrimSpace(req.Code))
+	if step == 0 {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	codes := generateRecoveryCodes()
+	tx, err := db.Begin(context.TODO())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer tx.Rollback(context.TODO())
+
+	if _, err := tx.Exec(context.TODO(), "UPDATE users SET totpEnabled = true, totpLastStep = $1 WHERE id = $2", step, user.ID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if _, err := tx.Exec(context.TODO(), "DELETE FROM totp_recovery_codes WHERE userId = $1", user.ID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(context.TODO(), "INSERT INTO totp_recovery_codes (userId, codeHash) VALUES ($1, $2)", user.ID, hashRecoveryCode(code)); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			log.Print(err)
+			return
+		}
+	}
+	if err := tx.Commit(context.TODO()); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditUpdate, auditUser, user.ID, auditTOTP{false}, auditTOTP{true})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnableResponse{RecoveryCodes: codes})
+}
+
+// Handle the /api/totpDisable route, requires the user's password, or a code for SSO users
+func totpDisableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user := currentUser(r)
+	if user.Scope != "" {
+		http.Error(w, "API tokens cannot manage two-factor authentication", http.StatusForbidden)
+		return
+	}
+
+	var req TOTPReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	var password []byte
+	var sso bool
+	if err := db.QueryRow(context.TODO(), "SELECT password, oidcSubject IS NOT NULL FROM users WHERE id = $1", user.ID).Scan(&password, &sso); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	// SSO users were given a password nobody knows, they confirm with a code instead
+	if sso && req.Code != "" {
+		ok, err := verifyUserTOTP(user.ID, req.Code)
+		if err == pgx.ErrNoRows {
+			http.Error(w, "Two-factor authentication isn't enabled", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			log.Print(err)
+			return
+		}
+		if !ok {
+			http.Error(w, "Invalid code", http.StatusForbidden)
+			return
+		}
+	} else if err := bcrypt.CompareHashAndPassword(password, []byte(req.Password)); err != nil {
+		http.Error(w, "Invalid password", http.StatusForbidden)
+		return
+	}
+
+	if err := disableTOTP(user.ID); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditUpdate, auditUser, user.ID, auditTOTP{true}, auditTOTP{false})
+}
+
+// auditTOTP is the audit log value for two-factor authentication changes
+type auditTOTP struct {
+	TOTPEnabled bool `json:"totpEnabled"`
+}
+
+// disableTOTP turns off two-factor authentication for a user and removes their recovery codes
+func disableTOTP(userID int) error {
+	if _, err := db.Exec(context.TODO(), "UPDATE users SET totpEnabled = false, totpSecret = NULL, totpLastStep = 0 WHERE id = $1", userID); err != nil {
+		return err
+	}
+	_, err := db.Exec(context.TODO(), "DELETE FROM totp_recovery_codes WHERE userId = $1", userID)
+	return err
+}
@@ -1,23 +1,77 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Config struct {
-	DatabaseURL      string    `json:"databaseURL"`
-	InitPwd          string    `json:"initPassword"`
-	InitUsr          string    `json:"initUser"`
-	ListenAddr       string    `json:"listenAddr"`
-	DaysDomainExp    int       `json:"remindDomainExpDays"`
-	DaysCertExp      int       `json:"remindCertExpDays"`
-	EmailForExp      string    `json:"to_email"`
-	FromEmail        string    `json:"from_email"`
-	SMTPHost         string    `json:"smtp_host"`
-	SMTP_USER        string    `json:"SMTP_USER"`
-	SMTPPass         string    `json:"SMTP_PASSWORD"`
-	SMTPPort         int       `json:"smtp_port"`
-	BaseURL          string    `json:"baseURL"`
-	DBInitFile       string    `json:"dbInitFile"`
-	LastReminderSent time.Time `json:"lastReminderSent"`
+	DatabaseURL         string          `json:"databaseURL"`
+	InitPwd             string          `json:"initPassword"`
+	InitUsr             string          `json:"initUser"`
+	ListenAddr          string          `json:"listenAddr"`
+	DaysDomainExp       int             `json:"remindDomainExpDays"`
+	DaysCertExp         int             `json:"remindCertExpDays"`
+	EmailForExp         string          `json:"to_email"`
+	FromEmail           string          `json:"from_email"`
+	SMTPHost            string          `json:"smtp_host"`
+	SMTP_USER           string          `json:"SMTP_USER"`
+	SMTPPass            string          `json:"SMTP_PASSWORD"`
+	SMTPPort            int             `json:"smtp_port"`
+	BaseURL             string          `json:"baseURL"`
+	LastReminderSent    time.Time       `json:"lastReminderSent"`
+	OIDC                *OIDCConfig     `json:"oidc,omitempty"`
+	TrustedProxies      []string        `json:"trustedProxies,omitempty"` // IPs/CIDRs allowed to set X-Forwarded-For
+	Resolver            *ResolverConfig `json:"resolver,omitempty"`
+	DKIMSelectors       []string        `json:"dkimSelectors,omitempty"`       // tried for every domain, see defaultDKIMSelectors
+	RefreshDays         int             `json:"refreshDays,omitempty"`         // days between RDAP/whois refreshes of a domain, default 30
+	ReminderRefreshDays int             `json:"reminderRefreshDays,omitempty"` // the same within the reminder period, default 1
+}
+
+// Single sign-on with an OpenID Connect identity provider
+type OIDCConfig struct {
+	Issuer        string          `json:"issuer"`
+	ClientID      string          `json:"clientID"`
+	ClientSecret  string          `json:"clientSecret,omitempty"`
+	Scopes        []string        `json:"scopes,omitempty"`        // default: openid profile email groups
+	UsernameClaim string          `json:"usernameClaim,omitempty"` // default: preferred_username
+	GroupsClaim   string          `json:"groupsClaim,omitempty"`   // default: groups
+	RoleMapping   map[string]Role `json:"roleMapping,omitempty"`   // group -> role
+	DefaultRole   Role            `json:"defaultRole,omitempty"`   // role for users without a mapped group, empty denies them
+}
+
+func (c *OIDCConfig) Enabled() bool {
+	return c != nil && c.Issuer != "" && c.ClientID != ""
+}
+
+func (c *OIDCConfig) scopes() []string {
+	if len(c.Scopes) == 0 {
+		return []string{"openid", "profile", "email", "groups"}
+	}
+	return c.Scopes
+}
+
+func (c *OIDCConfig) usernameClaim() string {
+	if c.UsernameClaim == "" {
+		return "preferred_username"
+	}
+	return c.UsernameClaim
+}
+
+func (c *OIDCConfig) groupsClaim() string {
+	if c.GroupsClaim == "" {
+		return "groups"
+	}
+	return c.GroupsClaim
+}
+
+// ResolverConfig selects the DNS resolver, see resolver.go
+type ResolverConfig struct {
+	Type    string   `json:"type"`    // system, doh (default), udp or tcp
+	URL     string   `json:"url"`     // DoH endpoint
+	Format  string   `json:"format"`  // DoH format: wire (RFC 8484, default) or json
+	Servers []string `json:"servers"` // udp/tcp: host or host:port
+	Timeout int      `json:"timeout"` // seconds per query
 }
 
 type LoginRequest struct {
@@ -25,28 +79,272 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type LoginResponse struct {
+	TOTPRequired bool   `json:"totpRequired"`
+	Challenge    string `json:"challenge,omitempty"`
+}
+
+type LoginOptions struct {
+	SSO bool `json:"sso"`
+}
+
+type LoginTOTPRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"` // TOTP or recovery code
+}
+
 type DbUser struct {
-	ID       int
-	Username string
-	Password []byte
+	ID          int
+	Username    string
+	Password    []byte
+	Role        Role
+	Disabled    bool
+	TOTPEnabled bool
+	LockedUntil *time.Time
+}
+
+type LoginAttempt struct {
+	ID        int       `db:"id" json:"id"`
+	At        time.Time `db:"at" json:"at"`
+	Username  string    `db:"username" json:"username"`
+	UserID    *int      `db:"userid" json:"userID,omitempty"`
+	IP        string    `db:"ip" json:"ip"`
+	UserAgent string    `db:"useragent" json:"userAgent"`
+	Success   bool      `db:"success" json:"success"`
+	Reason    string    `db:"reason" json:"reason"`
 }
 
 type Session struct {
-	Token  string
-	UserID int
-	Expiry time.Time
+	ID       int
+	Token    string
+	UserID   int
+	Expiry   time.Time
+	LastSeen time.Time
+}
+
+// A user's active session as shown to them (without the token)
+type SessionInfo struct {
+	ID        int       `db:"id" json:"id"`
+	UserID    int       `db:"userid" json:"userID"`
+	Created   time.Time `db:"created" json:"created"`
+	LastSeen  time.Time `db:"lastseen" json:"lastSeen"`
+	Expires   time.Time `db:"expires" json:"expires"`
+	IP        *string   `db:"ip" json:"ip,omitempty"`
+	UserAgent *string   `db:"useragent" json:"userAgent,omitempty"`
+	Current   bool      `db:"current" json:"current"`
+}
+
+// AuthUser is the user behind an authenticated request
+type AuthUser struct {
+	ID        int        `json:"id"`
+	Username  string     `json:"username"`
+	Role      Role       `json:"role"`
+	ClientIDs []int      `json:"clientIDs,omitempty"`
+	Scope     TokenScope `json:"scope,omitempty"` // only set when authenticated with an API token
+	SessionID int        `json:"-"`               // only set when authenticated with the session cookie
+
+	sessionLastSeen time.Time
+}
+
+type Me struct {
+	AuthUser
+	TOTPEnabled bool `json:"totpEnabled"`
+	SSO         bool `json:"sso"` // created by an OpenID Connect sign in, without a known password
+}
+
+type User struct {
+	ID        int    `db:"id" json:"id"`
+	Username  string `db:"username" json:"username"`
+	Role      Role   `db:"role" json:"role"`
+	Disabled  bool   `db:"disabled" json:"disabled"`
+	ClientIDs []int  `db:"clientids" json:"clientIDs"`
+}
+
+type UserReqBody struct {
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Role      Role   `json:"role"`
+	ClientIDs []int  `json:"clientIDs,omitempty"`
+}
+
+type UserEditReqBody struct {
+	ID        int     `json:"id"`
+	Role      *Role   `json:"role,omitempty"`
+	Disabled  *bool   `json:"disabled,omitempty"`
+	Password  *string `json:"password,omitempty"`
+	ClientIDs *[]int  `json:"clientIDs,omitempty"`
+	ResetTOTP bool    `json:"resetTOTP,omitempty"` // turn off two-factor authentication (lost authenticator)
+}
+
+type APIToken struct {
+	ID       int        `db:"id" json:"id"`
+	UserID   int        `db:"userid" json:"userID"`
+	Name     string     `db:"name" json:"name"`
+	Scope    TokenScope `db:"scope" json:"scope"`
+	Created  time.Time  `db:"created" json:"created"`
+	Expires  *time.Time `db:"expires" json:"expires,omitempty"`
+	LastUsed *time.Time `db:"lastused" json:"lastUsed,omitempty"`
+}
+
+type TokenReqBody struct {
+	Name          string     `json:"name"`
+	Scope         TokenScope `json:"scope"`
+	ExpiresInDays int        `json:"expiresInDays,omitempty"` // 0 never expires
+	UserID        int        `json:"userID,omitempty"`        // admins can create (service) tokens for other users
+}
+
+type NewTokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI
+	QR     string `json:"qr"`  // data: URL of a PNG QR code of the URI
+}
+
+type TOTPReqBody struct {
+	Code     string `json:"code,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type TOTPEnableResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type AuditEntry struct {
+	ID       int             `db:"id" json:"id"`
+	At       time.Time       `db:"at" json:"at"`
+	Actor    string          `db:"actor" json:"actor"`
+	UserID   *int            `db:"userid" json:"userID,omitempty"`
+	Action   string          `db:"action" json:"action"`
+	Entity   string          `db:"entity" json:"entity"`
+	EntityID int             `db:"entityid" json:"entityID"`
+	Before   json.RawMessage `db:"before" json:"before,omitempty"`
+	After    json.RawMessage `db:"after" json:"after,omitempty"`
+}
+
+type Reminders struct {
+	Domains []Domain    `json:"domains"`
+	Certs   []TLSDomain `json:"certs"`
 }
 
 type Domain struct {
-	ID           int       `db:"id" json:"id"`
-	Domain       string    `db:"domain" json:"domain"`
-	Expiration   time.Time `db:"expiration" json:"expiration"`
-	Nameservers  []string  `db:"nameservers" json:"nameservers,omitempty"`
-	Registrar    string    `db:"registrar" json:"registrar"`
-	DNS          DNS       `db:"dns" json:"dns"`
-	ClientID     int       `db:"clientid" json:"clientID"`
-	RawWhoisData string    `db:"rawwhoisdata" json:"rawWhoisData"`
-	Notes        *string   `db:"notes" json:"notes,omitempty"`
+	ID                int           `db:"id" json:"id"`
+	Domain            string        `db:"domain" json:"domain"`
+	Expiration        time.Time     `db:"expiration" json:"expiration"`
+	Nameservers       []string      `db:"nameservers" json:"nameservers,omitempty"`
+	Registrar         string        `db:"registrar" json:"registrar"`
+	DNS               DNS           `db:"dns" json:"dns"`
+	ClientID          int           `db:"clientid" json:"clientID"`
+	RawWhoisData      string        `db:"rawwhoisdata" json:"rawWhoisData"`
+	Notes             *string       `db:"notes" json:"notes,omitempty"`
+	NSCheck           *NSCheck      `db:"nscheck" json:"nsCheck,omitempty"`
+	DS                []DSRecord    `db:"ds" json:"ds,omitempty"` // from RDAP, nil when the registry didn't say
+	DNSSEC            *DNSSECStatus `db:"dnssec" json:"dnssec,omitempty"`
+	CAA               *CAAPolicy    `db:"caa" json:"caa,omitempty"`
+	Status            []string      `db:"status" json:"status,omitempty"` // EPP status codes, see eppStatus.go
+	RegistrantOrg     *string       `db:"registrantorg" json:"registrantOrg,omitempty"`
+	RegistrantCountry *string       `db:"registrantcountry" json:"registrantCountry,omitempty"`
+	RegistrantPrivacy *string       `db:"registrantprivacy" json:"registrantPrivacy,omitempty"` // see contacts.go, nil until fetched
+	TechContact       *Contact      `db:"techcontact" json:"techContact,omitempty"`
+	AbuseContact      *Contact      `db:"abusecontact" json:"abuseContact,omitempty"`
+	DataSource        *string       `db:"datasource" json:"dataSource,omitempty"`       // rdap or whois, nil until fetched
+	LastCheckedAt     *time.Time    `db:"lastcheckedat" json:"lastCheckedAt,omitempty"` // last successful RDAP/whois refresh
+	NextCheckAt       *time.Time    `db:"nextcheckat" json:"nextCheckAt,omitempty"`
+}
+
+// Contact is a registrant, tech or abuse contact from RDAP/whois, redacted values are left empty
+type Contact struct {
+	Name         string `json:"name,omitempty"`
+	Organization string `json:"organization,omitempty"`
+	Country      string `json:"country,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Phone        string `json:"phone,omitempty"`
+	Privacy      string `json:"privacy"` // public, redacted, privacy (proxy service) or none
+}
+
+type DomainContacts struct {
+	Registrant *Contact
+	Tech       *Contact
+	Abuse      *Contact
+}
+
+// CAAPolicy is the CAA record set that applies to a name, for a certificate also whether its CA may issue it
+type CAAPolicy struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	Name      string    `json:"name,omitempty"` // where the records were found (the name or a parent), empty without CAA
+	Records   []string  `json:"records"`        // e.g. 0 issue "letsencrypt.org"
+	CA        string    `json:"ca,omitempty"`   // CAA issuer domain of the certificate's CA, empty when not known
+	Permitted *bool     `json:"permitted,omitempty"`
+	Problems  []string  `json:"problems"`
+}
+
+// DomainData is what fetchDomainData finds out about a domain
+type DomainData struct {
+	Expiration  time.Time
+	Nameservers []string
+	Registrar   string
+	RawData     string
+	DNS         DNS
+	DS          []DSRecord
+	Status      []string
+	Contacts    DomainContacts
+	Source      string // sourceRDAP or sourceWhois
+}
+
+type DSRecord struct {
+	KeyTag     uint16 `json:"keyTag"`
+	Algorithm  uint8  `json:"algorithm"`
+	DigestType uint8  `json:"digestType"`
+	Digest     string `json:"digest"`
+}
+
+// DNSSECStatus is the result of validating a domain's DNSSEC chain from the DS records to the zone
+type DNSSECStatus struct {
+	CheckedAt           time.Time    `json:"checkedAt"`
+	State               string       `json:"state"`    // unsigned, insecure (signed without DS), secure or bogus
+	DSSource            string       `json:"dsSource"` // rdap or dns
+	DS                  []DSRecord   `json:"ds"`
+	Server              string       `json:"server,omitempty"` // the authoritative nameserver queried
+	Keys                []DNSKEYInfo `json:"keys"`
+	Signatures          []RRSIGInfo  `json:"signatures"`
+	SignatureExpiration *time.Time   `json:"signatureExpiration,omitempty"` // earliest expiration of the checked signatures
+	Problems            []string     `json:"problems"`
+}
+
+type DNSKEYInfo struct {
+	KeyTag    uint16 `json:"keyTag"`
+	Algorithm uint8  `json:"algorithm"`
+	Flags     uint16 `json:"flags"`
+	DS        bool   `json:"ds"` // matches a DS record
+}
+
+type RRSIGInfo struct {
+	Covers     string    `json:"covers"`
+	KeyTag     uint16    `json:"keyTag"`
+	Algorithm  uint8     `json:"algorithm"`
+	Inception  time.Time `json:"inception"`
+	Expiration time.Time `json:"expiration"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NSCheck is the result of querying a domain's authoritative nameservers directly
+type NSCheck struct {
+	CheckedAt  time.Time       `json:"checkedAt"`
+	Delegation []string        `json:"delegation"` // NS set from the parent zone (RDAP/whois)
+	Servers    []NSServerCheck `json:"servers"`
+	Problems   []string        `json:"problems"`
+}
+
+type NSServerCheck struct {
+	Host   string   `json:"host"`
+	IP     string   `json:"ip,omitempty"`
+	Serial *uint32  `json:"serial,omitempty"`
+	NS     []string `json:"ns,omitempty"`
+	Lame   bool     `json:"lame"`
+	Error  string   `json:"error,omitempty"`
 }
 
 type Client struct {
@@ -67,10 +365,60 @@ type EditReqBody struct {
 }
 
 type DNS struct {
-	A    []string `json:"a"`
-	AAAA []string `json:"aaaa"`
-	MX   []string `json:"mx"`
-	NS   []string `json:"ns"`
+	A    []string      `json:"a"`
+	AAAA []string      `json:"aaaa"`
+	MX   []string      `json:"mx"`
+	NS   []string      `json:"ns"`
+	Mail *MailSecurity `json:"mail,omitempty"`
+}
+
+// MailSecurity is the email authentication posture of a domain, a nil record wasn't found
+type MailSecurity struct {
+	SPF    *SPFResult    `json:"spf,omitempty"`
+	DMARC  *DMARCResult  `json:"dmarc,omitempty"`
+	DKIM   []DKIMResult  `json:"dkim,omitempty"`
+	MTASTS *MTASTSResult `json:"mtaSts,omitempty"`
+	TLSRPT *TLSRPTResult `json:"tlsRpt,omitempty"`
+	Score  int           `json:"score"`
+	Grade  string        `json:"grade"`
+	Issues []string      `json:"issues,omitempty"`
+	Failed []string      `json:"failed,omitempty"` // checks whose lookup failed
+}
+
+type SPFResult struct {
+	Record  string   `json:"record"`
+	All     string   `json:"all"` // qualifier of the all mechanism: -, ~, ? or + (also + without one)
+	Lookups int      `json:"lookups"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+type DMARCResult struct {
+	Record          string   `json:"record"`
+	Policy          string   `json:"policy"`
+	SubdomainPolicy string   `json:"subdomainPolicy,omitempty"`
+	Pct             int      `json:"pct"`
+	RUA             []string `json:"rua,omitempty"`
+}
+
+type DKIMResult struct {
+	Selector string `json:"selector"`
+	KeyType  string `json:"keyType"`
+	Bits     int    `json:"bits,omitempty"`
+	Revoked  bool   `json:"revoked,omitempty"`
+}
+
+type MTASTSResult struct {
+	Record string   `json:"record"`
+	ID     string   `json:"id"`
+	Mode   string   `json:"mode,omitempty"`
+	MX     []string `json:"mx,omitempty"`
+	MaxAge int      `json:"maxAge,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+type TLSRPTResult struct {
+	Record string   `json:"record"`
+	RUA    []string `json:"rua,omitempty"`
 }
 
 type DNSQuestion struct {
@@ -85,7 +433,7 @@ type DNSAnswer struct {
 	Data string `json:"data"`
 }
 
-type GoogDNSResponse struct {
+type DNSJSONResponse struct {
 	Status   int
 	TC       bool
 	RD       bool
@@ -98,19 +446,160 @@ type GoogDNSResponse struct {
 }
 
 type NSChange struct {
-	Domain    string    `json:"domain"`
-	OldNS     []string  `json:"oldNS"`
-	NewNS     []string  `json:"newNS"`
-	CheckedAt time.Time `json:"checkedAt"`
+	ID         int        `db:"id" json:"id"`
+	DomainID   int        `db:"domainid" json:"domainID"`
+	Domain     string     `db:"domain" json:"domain"`
+	ClientID   int        `db:"clientid" json:"clientID"`
+	OldNS      []string   `db:"oldns" json:"oldNS"`
+	NewNS      []string   `db:"newns" json:"newNS"`
+	CheckedAt  time.Time  `db:"checkedat" json:"checkedAt"`
+	Status     string     `db:"status" json:"status"`
+	ReviewedBy *string    `db:"reviewedby" json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time `db:"reviewedat" json:"reviewedAt,omitempty"`
+	Note       *string    `db:"note" json:"note,omitempty"`
+}
+
+type NSChangeReviewReqBody struct {
+	ID     int     `json:"id"`
+	Status string  `json:"status"`
+	Note   *string `json:"note"`
 }
 
 type TLSDomain struct {
-	ID         int       `db:"id" json:"id"`
-	Domain     string    `db:"domain" json:"domain"`
-	CommonName string    `db:"commonname" json:"commonName"`
-	Expiration time.Time `db:"expiration" json:"expiration"`
-	Authority  string    `db:"authority" json:"authority,omitempty"`
-	ClientID   int       `db:"clientid" json:"clientID"`
-	RawData    string    `db:"rawdata" json:"rawData"`
-	Notes      *string   `db:"notes" json:"notes,omitempty"`
+	ID         int        `db:"id" json:"id"`
+	Domain     string     `db:"domain" json:"domain"`
+	CommonName string     `db:"commonname" json:"commonName"`
+	Expiration time.Time  `db:"expiration" json:"expiration"`
+	Authority  string     `db:"authority" json:"authority,omitempty"`
+	ClientID   int        `db:"clientid" json:"clientID"`
+	RawData    string     `db:"rawdata" json:"rawData"`
+	Notes      *string    `db:"notes" json:"notes,omitempty"`
+	CAA        *CAAPolicy `db:"caa" json:"caa,omitempty"`
+	// NULL until the certificate is refreshed, for entries added before they were stored
+	DNSNames           []string       `db:"dnsnames" json:"dnsNames,omitempty"`
+	IPAddresses        []string       `db:"ipaddresses" json:"ipAddresses,omitempty"`
+	Serial             *string        `db:"serial" json:"serial,omitempty"`
+	Fingerprint        *string        `db:"fingerprint" json:"fingerprint,omitempty"` // SHA-256 of the DER certificate, hex
+	KeyType            *string        `db:"keytype" json:"keyType,omitempty"`
+	KeyBits            *int           `db:"keybits" json:"keyBits,omitempty"`
+	SignatureAlgorithm *string        `db:"signaturealgorithm" json:"signatureAlgorithm,omitempty"`
+	Chain              []ChainCert    `db:"chain" json:"chain,omitempty"`       // as presented, leaf first
+	RootHint           *string        `db:"roothint" json:"rootHint,omitempty"` // the root the chain leads to
+	Port               int            `db:"port" json:"port"`
+	SNI                *string        `db:"sni" json:"sni,omitempty"`
+	Protocol           string         `db:"protocol" json:"protocol"` // see starttls.go
+	PerIP              bool           `db:"perip" json:"perIP"`       // check every address of the host
+	Endpoints          []IPCert       `db:"endpoints" json:"endpoints,omitempty"`
+	Validation         *TLSValidation `db:"validation" json:"validation,omitempty"`
+}
+
+// TLSValidation is the result of verifying a certificate, see validation.go for the statuses
+type TLSValidation struct {
+	CheckedAt time.Time `json:"checkedAt"`
+	Status    string    `json:"status"`
+	Problems  []string  `json:"problems"`
+	OCSP      string    `json:"ocsp,omitempty"` // not checked without a trusted chain
+}
+
+// IPCert is the certificate served by one address of a host
+type IPCert struct {
+	IP          string    `json:"ip"`
+	Serial      string    `json:"serial,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Expiration  time.Time `json:"expiration,omitzero"`
+	Status      string    `json:"status,omitempty"` // validation status
+	Error       string    `json:"error,omitempty"`  // the handshake failed
+}
+
+type TLSReqBody struct {
+	Domain   string `json:"domain"` // host name or IP address
+	ClientID int    `json:"clientID"`
+	Notes    string `json:"notes,omitempty"`
+	Port     int    `json:"port,omitempty"`     // default for the protocol
+	SNI      string `json:"sni,omitempty"`      // server name to send instead of the host
+	Protocol string `json:"protocol,omitempty"` // default tls
+	PerIP    bool   `json:"perIP,omitempty"`
+}
+
+// TLSCertData is what getTLSCert finds out about a server's certificate
+type TLSCertData struct {
+	CommonName         string
+	Expiration         time.Time
+	Authority          string
+	RawData            []byte
+	DNSNames           []string
+	IPAddresses        []string
+	Serial             string
+	Fingerprint        string
+	KeyType            string
+	KeyBits            int
+	SignatureAlgorithm string
+	Chain              []ChainCert
+	RootHint           string
+	Validation         TLSValidation
+}
+
+// CABundle is an uploaded set of private CA certificates, for a client's TLS entries or a single entry
+type CABundle struct {
+	ID       int         `db:"id" json:"id"`
+	Name     string      `db:"name" json:"name"`
+	ClientID *int        `db:"clientid" json:"clientID,omitempty"`
+	CertID   *int        `db:"certid" json:"certID,omitempty"`
+	PEM      string      `db:"pem" json:"pem"`
+	Certs    []ChainCert `db:"certs" json:"certs"` // the bundle's certificates, for their expiry
+	Created  time.Time   `db:"created" json:"created"`
+}
+
+type CABundleReqBody struct {
+	Name     string `json:"name"`
+	ClientID int    `json:"clientID,omitempty"` // one of clientID and certID
+	CertID   int    `json:"certID,omitempty"`
+	PEM      string `json:"pem"`
+}
+
+type ChainCert struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	Serial             string    `json:"serial"`
+	Fingerprint        string    `json:"fingerprint"`
+	NotBefore          time.Time `json:"notBefore"`
+	NotAfter           time.Time `json:"notAfter"`
+	KeyType            string    `json:"keyType"`
+	KeyBits            int       `json:"keyBits,omitempty"`
+	SignatureAlgorithm string    `json:"signatureAlgorithm"`
+	CA                 bool      `json:"ca"`
+	SelfSigned         bool      `json:"selfSigned"`
+}
+
+type DomainHistory struct {
+	ID            int        `db:"id" json:"id"`
+	DomainID      int        `db:"domainid" json:"domainID"`
+	RecordedAt    time.Time  `db:"recordedat" json:"recordedAt"`
+	Changes       []string   `db:"changes" json:"changes"`
+	OldExpiration *time.Time `db:"oldexpiration" json:"oldExpiration,omitempty"`
+	Expiration    time.Time  `db:"expiration" json:"expiration"`
+	OldRegistrar  *string    `db:"oldregistrar" json:"oldRegistrar,omitempty"`
+	Registrar     string     `db:"registrar" json:"registrar"`
+	RawWhoisData  *string    `db:"rawwhoisdata" json:"rawWhoisData,omitempty"`
+}
+
+type DNSSnapshot struct {
+	ID       int       `db:"id" json:"id"`
+	DomainID int       `db:"domainid" json:"domainID"`
+	TakenAt  time.Time `db:"takenat" json:"takenAt"`
+	DNS      DNS       `db:"dns" json:"dns"`
+	Changed  bool      `db:"changed" json:"changed"`
+}
+
+type DNSRecordChange struct {
+	Type    string   `json:"type"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+type DNSDiff struct {
+	DomainID int               `json:"domainID"`
+	From     *DNSSnapshot      `json:"from"`
+	To       DNSSnapshot       `json:"to"`
+	Changes  []DNSRecordChange `json:"changes"`
 }
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Handle the /api/me route, returns the logged in user
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	me := Me{AuthUser: currentUser(r)}
+	err := db.QueryRow(context.TODO(), "SELECT totpEnabled, oidcSubject IS NOT NULL FROM users WHERE id = $1", me.ID).Scan(&me.TOTPEnabled, &me.SSO)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(me)
+}
+
+// Handle the /api/userList route
+func userListHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rows, err := db.Query(context.TODO(), `
+		SELECT u.id, u.username, u.role, u.disabled,
+			COALESCE(array_agg(uc.clientId ORDER BY uc.clientId) FILTER (WHERE uc.clientId IS NOT NULL), '{}') AS clientids
+		FROM users u
+		LEFT JOIN user_clients uc ON uc.userId = u.id
+		GROUP BY u.id
+		ORDER BY u.id
+	`)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer rows.Close()
+
+	users, err := pgx.CollectRows(rows, pgx.RowToStructByName[User])
+	if err != nil {
+		http.Error(w, "Error reading users", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// Handle the /api/userAdd route
+func userAddHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UserReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+
+	// Same limits as the login form
+	if len(req.Username) < 5 || len(req.Password) < 8 {
+		http.Error(w, "Username must be at least 5 characters and password at least 8", http.StatusBadRequest)
+		return
+	}
+	if !req.Role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	// Client accounts must be bound to at least one client
+	if req.Role == RoleClient && len(req.ClientIDs) == 0 {
+		http.Error(w, "Client accounts need at least one client", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	tx, err := db.Begin(context.TODO())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer tx.Rollback(context.TODO())
+
+	user := User{ClientIDs: []int{}}
+	err = tx.QueryRow(context.TODO(), "INSERT INTO users (username, password, role) VALUES ($1, $2, $3) RETURNING id, username, role, disabled", req.Username, hashedPassword, req.Role).
+		Scan(&user.ID, &user.Username, &user.Role, &user.Disabled)
+	if err != nil {
+		if isUniqueViolation(err) {
+			http.Error(w, "Username already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to add user", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	if req.Role == RoleClient {
+		if err := setUserClients(tx, user.ID, req.ClientIDs); err != nil {
+			http.Error(w, "Failed to assign clients (check the client IDs)", http.StatusBadRequest)
+			log.Print(err)
+			return
+		}
+		user.ClientIDs = req.ClientIDs
+	}
+
+	if err := tx.Commit(context.TODO()); err != nil {
+		http.Error(w, "Failed to add user", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	recordAudit(userActor(r), auditCreate, auditUser, user.ID, nil, user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// Handle the /api/userEdit route (change role, disable/enable, reset password or two-factor authentication, reassign clients)
+func userEditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UserEditReqBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		log.Println(err)
+		return
+	}
+	if req.ID == 0 || (req.Role == nil && req.Disabled == nil && req.Password == nil && req.ClientIDs == nil && !req.ResetTOTP) {
+		http.Error(w, "Missing required fields", http.StatusBadRequest)
+		return
+	}
+
+	// Don't let an admin lock themselves out
+	if req.ID == currentUser(r).ID && (req.Role != nil || req.Disabled != nil) {
+		http.Error(w, "You cannot change your own role or disable yourself", http.StatusBadRequest)
+		return
+	}
+
+	if req.Role != nil && !req.Role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	var hashedPassword []byte
+	if req.Password != nil {
+		if len(*req.Password) < 8 {
+			http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+			return
+		}
+		var err error
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(*req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+			log.Print(err)
+			return
+		}
+	}
+
+	// Keep the old values for the audit log
+	before, err := getUser(req.ID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	tx, err := db.Begin(context.TODO())
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	defer tx.Rollback(context.TODO())
+
+	c, err := tx.Exec(context.TODO(), "UPDATE users SET role = COALESCE($1, role), disabled = COALESCE($2, disabled), password = COALESCE($3, password) WHERE id = $4",
+		req.Role, req.Disabled, hashedPassword, req.ID)
+	if err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+	if c.RowsAffected() == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if req.ClientIDs != nil {
+		if err := setUserClients(tx, req.ID, *req.ClientIDs); err != nil {
+			http.Error(w, "Failed to assign clients (check the client IDs)", http.StatusBadRequest)
+			log.Print(err)
+			return
+		}
+	}
+
+	if err := tx.Commit(context.TODO()); err != nil {
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		log.Print(err)
+		return
+	}
+
+	if req.ResetTOTP {
+		if err := disableTOTP(req.ID); err != nil {
+			http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+			log.Print(err)
+			return
+		}
+	}
+
+	// Disabling a user or resetting their password ends their sessions
+	if (req.Disabled != nil && *req.Disabled) || req.Password != nil {
+		if _, err := db.Exec(context.TODO(), "DELETE FROM sessions WHERE userId = $1", req.ID); err != nil {
+			log.Printf("Failed to delete sessions for user %d: %v\n", req.ID, err)
+		}
+	}
+
+	after, err := getUser(req.ID)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	// Passwords never go into the audit log, only the fact that they changed
+	type auditUserEdit struct {
+		User
+		PasswordReset bool `json:"passwordReset,omitempty"`
+		TOTPReset     bool `json:"totpReset,omitempty"`
+	}
+	recordAudit(userActor(r), auditUpdate, auditUser, req.ID, before, auditUserEdit{after, req.Password != nil, req.ResetTOTP})
+}
+
+// getUser returns a user (without secrets) by ID
+func getUser(id int) (User, error) {
+	rows, err := db.Query(context.TODO(), `
+		SELECT u.id, u.username, u.role, u.disabled,
+			COALESCE(array_agg(uc.clientId ORDER BY uc.clientId) FILTER (WHERE uc.clientId IS NOT NULL), '{}') AS clientids
+		FROM users u
+		LEFT JOIN user_clients uc ON uc.userId = u.id
+		WHERE u.id = $1
+		GROUP BY u.id
+	`, id)
+	if err != nil {
+		return User{}, err
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[User])
+}
+
+// setUserClients replaces the clients a (client) user is bound to
+func setUserClients(tx pgx.Tx, userID int, clientIDs []int) error {
+	if _, err := tx.Exec(context.TODO(), "DELETE FROM user_clients WHERE userId = $1", userID); err != nil {
+		return err
+	}
+	for _, id := range clientIDs {
+		if _, err := tx.Exec(context.TODO(), "INSERT INTO user_clients (userId, clientId) VALUES ($1, $2) ON CONFLICT DO NOTHING", userID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
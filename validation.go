@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Validation states of a certificate, the first problem found in this order is the status
+const (
+	certValid            = "valid"
+	certRevoked          = "revoked"
+	certExpired          = "expired" // or not valid yet
+	certUntrusted        = "untrustedRoot"
+	certIncompleteChain  = "incompleteChain" // trusted once the missing intermediates are fetched
+	certHostnameMismatch = "hostnameMismatch"
+	certInvalid          = "invalid" // any other verification error
+)
+
+var certStatusOrder = []string{certRevoked, certExpired, certUntrusted, certIncompleteChain, certHostnameMismatch, certInvalid}
+
+// Revocation states from OCSP
+const (
+	ocspGood    = "good"
+	ocspRevoked = "revoked"
+	ocspUnknown = "unknown" // no responder, or it couldn't answer
+)
+
+var validationClient = &http.Client{Timeout: tlsTimeout}
+
+// validateCert verifies a presented chain against the roots (the system's when nil) and the server name
+// Each check runs on its own, so an expired certificate is still reported as untrusted or mismatched when it is
+func validateCert(certs []*x509.Certificate, serverName string, roots *x509.CertPool, staple []byte) TLSValidation {
+	v := TLSValidation{CheckedAt: time.Now(), Problems: []string{}}
+	found := map[string]bool{}
+	problem := func(status, msg string) {
+		found[status] = true
+		v.Problems = append(v.Problems, msg)
+	}
+
+	leaf := certs[0]
+	now := time.Now()
+	// The chain is built at a time the leaf was valid, an expired leaf is reported on its own
+	at := now
+	if now.After(leaf.NotAfter) {
+		problem(certExpired, fmt.Sprintf("Certificate expired %s", leaf.NotAfter.Format("01/02/2006")))
+		at = leaf.NotAfter.Add(-time.Minute)
+	} else if now.Before(leaf.NotBefore) {
+		problem(certExpired, fmt.Sprintf("Certificate not valid before %s", leaf.NotBefore.Format("01/02/2006")))
+		at = leaf.NotBefore.Add(time.Minute)
+	}
+	if err := leaf.VerifyHostname(serverName); err != nil {
+		problem(certHostnameMismatch, fmt.Sprintf("Certificate isn't valid for %s", serverName))
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+	opts := x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: at}
+	chains, err := leaf.Verify(opts)
+	var unknownAuthority x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthority) {
+		// Browsers fetch missing intermediates from the AIA URL, other clients fail: trusted this way means incomplete
+		if fetchIntermediates(certs, intermediates) {
+			chains, err = leaf.Verify(opts)
+			if err == nil {
+				problem(certIncompleteChain, "The server doesn't send all the intermediates")
+			}
+		}
+	}
+	var invalid x509.CertificateInvalidError
+	switch {
+	case err == nil:
+	case errors.As(err, &unknownAuthority):
+		problem(certUntrusted, "Certificate isn't signed by a trusted root")
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		// An intermediate or the root, the leaf's expiry is already reported
+		if !found[certExpired] {
+			problem(certExpired, "A certificate of the chain expired")
+		}
+	default:
+		problem(certInvalid, err.Error())
+	}
+
+	// Revocation is only meaningful for a chain leading to a trusted root
+	if len(chains) > 0 && len(chains[0]) > 1 {
+		v.OCSP = ocspStatus(leaf, chains[0][1], staple)
+		if v.OCSP == ocspRevoked {
+			problem(certRevoked, "Certificate was revoked")
+		}
+	}
+
+	v.Status = certValid
+	for _, s := range certStatusOrder {
+		if found[s] {
+			v.Status = s
+			break
+		}
+	}
+	return v
+}
+
+// fetchIntermediates adds the issuers the presented chain points to with its AIA URLs
+func fetchIntermediates(certs []*x509.Certificate, pool *x509.CertPool) bool {
+	added := false
+	cert := certs[len(certs)-1]
+	// A few levels at most, each fetched certificate can be missing its own issuer
+	for range 3 {
+		if len(cert.IssuingCertificateURL) == 0 || bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+			break
+		}
+		issuer, err := fetchCert(cert.IssuingCertificateURL[0])
+		if err != nil {
+			log.Printf("Failed to fetch intermediate of %s: %v\n", cert.Subject, err)
+			break
+		}
+		pool.AddCert(issuer)
+		added = true
+		cert = issuer
+	}
+	return added
+}
+
+func fetchCert(url string) (*x509.Certificate, error) {
+	res, err := validationClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, res.Status)
+	}
+	der, err := io.ReadAll(io.LimitReader(res.Body, 1<<16))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// ocspStatus checks the revocation of a certificate, with the response stapled in the handshake if there's one
+func ocspStatus(leaf, issuer *x509.Certificate, staple []byte) string {
+	if len(staple) == 0 {
+		if len(leaf.OCSPServer) == 0 {
+			return ocspUnknown
+		}
+		req, err := ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+		if err != nil {
+			return ocspUnknown
+		}
+		res, err := validationClient.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+		if err != nil {
+			log.Printf("OCSP request for %s failed: %v\n", leaf.Subject, err)
+			return ocspUnknown
+		}
+		defer res.Body.Close()
+		if staple, err = io.ReadAll(io.LimitReader(res.Body, 1<<16)); err != nil {
+			return ocspUnknown
+		}
+	}
+	resp, err := ocsp.ParseResponseForCert(staple, leaf, issuer)
+	if err != nil {
+		log.Printf("Invalid OCSP response for %s: %v\n", leaf.Subject, err)
+		return ocspUnknown
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return ocspGood
+	case ocsp.Revoked:
+		return ocspRevoked
+	}
+	return ocspUnknown
+}
+
+// certStatusName is how a validation status reads in emails
+func certStatusName(status string) string {
+	switch status {
+	case certUntrusted:
+		return "untrusted root"
+	case certIncompleteChain:
+		return "incomplete chain"
+	case certHostnameMismatch:
+		return "hostname mismatch"
+	}
+	return status
+}
+
+// validationChanged tells whether a refresh found a new validation problem (a different one counts too)
+func validationChanged(old *TLSValidation, new TLSValidation) bool {
+	return new.Status != certValid && (old == nil || old.Status != new.Status)
+}
+
+type validationAlert struct {
+	Cert TLSDomain
+}
+
+func sendValidationAlerts(alerts []validationAlert) {
+	var list string
+	for _, a := range alerts {
+		v := a.Cert.Validation
+		subtitle := fmt.Sprintf("Expires %s &middot; Authority: %s", a.Cert.Expiration.Format("01/02/2006"), a.Cert.Authority)
+		list += domainCard("#f85149", getConfig().BaseURL+"/dash/tls/?q="+certName(a.Cert), certName(a.Cert), subtitle,
+			strings.ToUpper(certStatusName(v.Status))+"<br>"+strings.Join(v.Problems, "<br>"))
+	}
+	intro := fmt.Sprintf(`<p style="margin:0 0 20px;font-size:14px;color:#57606a;">The certificate of <strong>%d endpoint(s)</strong> doesn't validate. Clients connecting to them get a certificate error.</p>`, len(alerts))
+	if err := sendEmail("Certificate validation problems detected", emailHTML("Certificate validation problems detected", intro+list)); err != nil {
+		log.Printf("Failed to send certificate validation alert email: %v\n", err)
+	}
+}